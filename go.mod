@@ -9,3 +9,5 @@ retract (
 	v1.0.0
 	v0.0.1
 )
+
+require github.com/ulikunitz/xz v0.5.17
@@ -3,30 +3,107 @@ package cli
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
 	"os"
+	"os/signal"
+	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
-
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/jakewnuk/maskcat/pkg/counter"
+	"github.com/jakewnuk/maskcat/pkg/dedupe"
+	"github.com/jakewnuk/maskcat/pkg/extsort"
+	"github.com/jakewnuk/maskcat/pkg/keyboard"
+	"github.com/jakewnuk/maskcat/pkg/markov"
+	"github.com/jakewnuk/maskcat/pkg/masks"
 	"github.com/jakewnuk/maskcat/pkg/models"
+	"github.com/jakewnuk/maskcat/pkg/pcfg"
+	"github.com/jakewnuk/maskcat/pkg/prince"
+	"github.com/jakewnuk/maskcat/pkg/score"
+	"github.com/jakewnuk/maskcat/pkg/semantic"
 	"github.com/jakewnuk/maskcat/pkg/utils"
 )
 
 // MatchMasks reads masks from a file and prints any input strings that match one of the masks
 //
+// The mask file can be a .hcmask file with custom charsets and masks can mix
+// literal text with mask characters such as the output of partial or retain.
+// Masks are indexed so each input string is matched without testing every
+// mask, see masks.Index for the worst case.
+//
 // Args:
 //
 //	stdIn (*bufio.Scanner): Buffer of standard input
 //	infile (string): File path of input file to use
 //	doMultiByte (bool): If multibyte text should be processed
 //	doDeHex (bool): If $HEX[...] text should be processed
+//	doInvert (bool): If input strings that do not match should be printed instead
+//	doAnnotate (bool): If matches should be printed as plaintext:mask:line
+//	doRegex (bool): If the file contains regular expressions instead of masks
+//	doJohn (bool): If the file contains John the Ripper masks
+//	custom (map[byte]masks.Charset): Custom charsets keyed by '1' to '4'
+//
+// Returns:
+//
+//	None
+func MatchMasks(stdIn *bufio.Scanner, infile string, doMultiByte bool, doDeHex bool, doInvert bool, doAnnotate bool, doRegex bool, doJohn bool, custom map[byte]masks.Charset) {
+	if doRegex {
+		matchRegex(stdIn, infile, doDeHex, doInvert, doAnnotate)
+		return
+	}
+
+	index, entries := LoadMaskIndex(infile, doJohn, custom)
+
+	for stdIn.Scan() {
+		stdText := dehexLine(stdIn.Text(), doDeHex)
+
+		// Multibyte text is never matched unless it is processed
+		id, ok := 0, false
+		if doMultiByte || models.IsStringASCII(stdText) {
+			id, ok = index.Match(stdText)
+		}
+
+		switch {
+		case ok && !doInvert && doAnnotate:
+			fmt.Printf("%s:%s:%d\n", stdText, entries[id].Mask, entries[id].Line)
+		case ok != doInvert:
+			fmt.Println(stdText)
+		}
+	}
+
+	if err := stdIn.Err(); err != nil {
+		CheckError(err)
+	}
+}
+
+// matchRegex reads regular expressions from a file and prints any input
+// strings that match one of them
+//
+// Args:
+//
+//	stdIn (*bufio.Scanner): Buffer of standard input
+//	infile (string): File path of regular expression file to use
+//	doDeHex (bool): If $HEX[...] text should be processed
+//	doInvert (bool): If input strings that do not match should be printed instead
+//	doAnnotate (bool): If matches should be printed as plaintext:regex:line
 //
 // Returns:
 //
 //	None
-func MatchMasks(stdIn *bufio.Scanner, infile string, doMultiByte bool, doDeHex bool) {
+func matchRegex(stdIn *bufio.Scanner, infile string, doDeHex bool, doInvert bool, doAnnotate bool) {
 	buf, err := os.Open(infile)
 	CheckError(err)
 
@@ -37,73 +114,105 @@ func MatchMasks(stdIn *bufio.Scanner, infile string, doMultiByte bool, doDeHex b
 		}
 	}()
 
+	type pattern struct {
+		re   *regexp.Regexp
+		line int
+	}
 	filescanner := bufio.NewScanner(buf)
-	var masks []string
-	args := utils.ConstructReplacements("ulds")
-	stdText := ""
+	patterns := []pattern{}
+	lineNumber := 0
 
 	for filescanner.Scan() {
-		if models.IsHashMask(filescanner.Text()) == false {
-			fmt.Println("[SKIP] Input mask contains non-mask characters: ", filescanner.Text())
+		lineNumber++
+		re, err := regexp.Compile(filescanner.Text())
+		if err != nil || filescanner.Text() == "" {
+			fmt.Println("[SKIP] Input regex could not be compiled: ", filescanner.Text())
 			continue
 		}
-		masks = append(masks, filescanner.Text())
+		patterns = append(patterns, pattern{re: re, line: lineNumber})
 	}
-
-	var wg sync.WaitGroup
+	CheckError(filescanner.Err())
 
 	for stdIn.Scan() {
+		stdText := dehexLine(stdIn.Text(), doDeHex)
 
-		if utils.TestHexInput(stdIn.Text()) == true && doDeHex == true {
-			plaintext, err := utils.DehexPlaintext(stdIn.Text())
-			if err != nil {
-				fmt.Println("error")
-				stdText = ""
+		var matched *pattern
+		for i := range patterns {
+			if patterns[i].re.MatchString(stdText) {
+				matched = &patterns[i]
+				break
 			}
-			stdText = plaintext
-		} else {
-			stdText = stdIn.Text()
 		}
 
-		mask := utils.MakeMask(stdText, args)
-		if doMultiByte {
-			mask = models.EnsureValidMask(mask)
+		switch {
+		case matched != nil && !doInvert && doAnnotate:
+			fmt.Printf("%s:%s:%d\n", stdText, matched.re, matched.line)
+		case (matched != nil) != doInvert:
+			fmt.Println(stdText)
 		}
+	}
 
-		wg.Add(1)
-		go func(mask string, stdText string) {
-			defer wg.Done()
-			for _, value := range masks {
-
-				if mask == value {
-					fmt.Println(stdText)
-					break
-				}
+	if err := stdIn.Err(); err != nil {
+		CheckError(err)
+	}
+}
 
-				if err := stdIn.Err(); err != nil {
-					CheckError(err)
-				}
+// ConvertRegex converts masks from the input strings into anchored regular
+// expressions or regular expressions into masks
+//
+// Args:
+//
+//	stdIn (*bufio.Scanner): Buffer of standard input
+//	doReverse (bool): If regular expressions should be converted into masks
+//
+// Returns:
+//
+//	None
+func ConvertRegex(stdIn *bufio.Scanner, doReverse bool) {
+	for stdIn.Scan() {
+		if doReverse {
+			mask, err := utils.RegexToMask(stdIn.Text())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[SKIP] %s: %s\n", stdIn.Text(), err)
+				continue
 			}
-		}(mask, stdText)
+			fmt.Println(mask)
+			continue
+		}
+
+		mask, charsets, err := masks.ParseHCMaskLine(stdIn.Text(), nil)
+		if err == nil {
+			mask, err = utils.MaskToRegex(mask, charsets)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[SKIP] %s: %s\n", stdIn.Text(), err)
+			continue
+		}
+		fmt.Println(mask)
+	}
+
+	if err := stdIn.Err(); err != nil {
+		CheckError(err)
 	}
-	wg.Wait()
 }
 
-// SubMasks reads tokens from a file and replaces mask characters in the input strings with the tokens
+// LoadMaskIndex reads masks from a mask or .hcmask file into an index
+//
+// Each mask is added to the index with its position in the returned entries
+// as its id. John the Ripper masks are converted into hashcat masks first and
+// keep their original text in the returned entries.
 //
 // Args:
 //
-//	stdIn (*bufio.Scanner): Buffer of standard input
-//	infile (string): File path of input file to use
-//	doMultiByte (bool): If multibyte text should be processed
-//	doDeHex (bool): If $HEX[...] text should be processed
-//	doNumberOfReplacements (int): Max number of times to replace per string
-//	doFuzzAmount(int): Number of additional fuzz characters to add to replacer
+//	infile (string): File path of mask file to use
+//	doJohn (bool): If the file contains John the Ripper masks
+//	custom (map[byte]masks.Charset): Custom charsets keyed by '1' to '4'
 //
 // Returns:
 //
-// None
-func SubMasks(stdIn *bufio.Scanner, infile string, doMultiByte bool, doDeHex bool, doNumberOfReplacements int, doFuzzAmount int) {
+//	index (*masks.Index): Index of masks
+//	entries ([]models.MaskEntry): Masks in file order
+func LoadMaskIndex(infile string, doJohn bool, custom map[byte]masks.Charset) (*masks.Index, []models.MaskEntry) {
 	buf, err := os.Open(infile)
 	CheckError(err)
 
@@ -115,22 +224,153 @@ func SubMasks(stdIn *bufio.Scanner, infile string, doMultiByte bool, doDeHex boo
 	}()
 
 	filescanner := bufio.NewScanner(buf)
-	tokens := make(map[string]struct{})
-	args := utils.ConstructReplacements("ulds")
+	index := masks.NewIndex()
+	entries := []models.MaskEntry{}
+	lineNumber := 0
 
 	for filescanner.Scan() {
-		if filescanner.Text() != "" {
-			tokens[filescanner.Text()] = struct{}{}
+		lineNumber++
+		line := filescanner.Text()
+		if doJohn {
+			converted, err := utils.ConvertJohnToHashcat(line, custom)
+			if err != nil {
+				fmt.Printf("[SKIP] Input mask cannot be translated (%s): %s\n", err, line)
+				continue
+			}
+			line = converted
 		}
 
-		if err := filescanner.Err(); err != nil {
-			CheckError(err)
+		mask, charsets, err := masks.ParseHCMaskLine(line, custom)
+		if err != nil || models.IsPartialMask(mask) == false {
+			fmt.Println("[SKIP] Input mask contains invalid mask characters: ", filescanner.Text())
+			continue
+		}
+
+		positions, err := masks.ParseMask(mask, charsets)
+		if err == nil {
+			err = index.Add(mask, charsets, len(entries))
+		}
+		if err != nil {
+			fmt.Println("[SKIP] Input mask could not be parsed: ", filescanner.Text())
+			continue
+		}
+		if doJohn {
+			mask = filescanner.Text()
+		}
+		entries = append(entries, models.MaskEntry{Line: lineNumber, Mask: mask, Keyspace: masks.Keyspace(positions)})
+	}
+
+	if err := filescanner.Err(); err != nil {
+		CheckError(err)
+	}
+	return index, entries
+}
+
+// CalculateCoverage matches input strings against masks from a file and
+// prints how many of the input strings each mask covers
+//
+// Args:
+//
+//	stdIn (*bufio.Scanner): Buffer of standard input
+//	infile (string): File path of mask file to use
+//	doMultiByte (bool): If multibyte text should be processed
+//	doDeHex (bool): If $HEX[...] text should be processed
+//	format (string): Output format of "table", "csv" or "json"
+//	doJohn (bool): If the file contains John the Ripper masks
+//	custom (map[byte]masks.Charset): Custom charsets keyed by '1' to '4'
+//
+// Returns:
+//
+//	None
+func CalculateCoverage(stdIn *bufio.Scanner, infile string, doMultiByte bool, doDeHex bool, format string, doJohn bool, custom map[byte]masks.Charset) {
+	if format != "table" && format != "csv" && format != "json" {
+		CheckError(errors.New("Invalid Output Format"))
+	}
+
+	index, entries := LoadMaskIndex(infile, doJohn, custom)
+	coverage := masks.NewCoverage(len(entries))
+
+	for stdIn.Scan() {
+		stdText := dehexLine(stdIn.Text(), doDeHex)
+		if !doMultiByte && !models.IsStringASCII(stdText) {
+			coverage.Add(nil)
+			continue
+		}
+		coverage.Add(index.MatchAll(stdText))
+	}
+
+	if err := stdIn.Err(); err != nil {
+		CheckError(err)
+	}
+
+	report := coverage.Report(entries)
+	switch format {
+	case "json":
+		out, err := json.MarshalIndent(report, "", "  ")
+		CheckError(err)
+		fmt.Println(string(out))
+	case "csv":
+		writer := csv.NewWriter(os.Stdout)
+		CheckError(writer.Write([]string{"mask", "line", "hits", "new_hits", "cumulative_percent", "keyspace", "hits_per_keyspace", "efficiency_rank"}))
+		for _, row := range report {
+			CheckError(writer.Write([]string{
+				row.Mask,
+				strconv.Itoa(row.Line),
+				strconv.Itoa(row.Hits),
+				strconv.Itoa(row.NewHits),
+				strconv.FormatFloat(row.Cumulative, 'f', 2, 64),
+				strconv.FormatFloat(row.Keyspace, 'f', 0, 64),
+				strconv.FormatFloat(row.Efficiency, 'g', 6, 64),
+				strconv.Itoa(row.Rank),
+			}))
+		}
+		writer.Flush()
+		CheckError(writer.Error())
+	default:
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "MASK\tLINE\tHITS\tNEW\tCUMULATIVE\tKEYSPACE\tHITS/KEYSPACE\tRANK")
+		for _, row := range report {
+			fmt.Fprintf(writer, "%s\t%d\t%d\t%d\t%.2f%%\t%.0f\t%.6g\t%d\n", row.Mask, row.Line, row.Hits, row.NewHits, row.Cumulative, row.Keyspace, row.Efficiency, row.Rank)
 		}
+		CheckError(writer.Flush())
+		fmt.Fprintf(os.Stderr, "[*] %d masks checked against %d plaintexts\n", len(report), coverage.Total())
 	}
+}
+
+// SubMasks reads tokens from a file and replaces mask characters in the input strings with the tokens
+//
+// Tokens are substituted in descending weight order when the token file is
+// weighted with count:token or token\tweight lines.
+//
+// Args:
+//
+//	stdIn (*bufio.Scanner): Buffer of standard input
+//	infile (string): File path of input file to use
+//	doWeighted (bool): If the token file has count:token or token\tweight lines
+//	doMultiByte (bool): If multibyte text should be processed
+//	doDeHex (bool): If $HEX[...] text should be processed
+//	doNumberOfReplacements (int): Max number of times to replace per string
+//	doFuzzAmount(int): Number of additional fuzz characters to add to replacer
+//	doMaxTokens (int): Max number of tokens to use per token mask (0 uses all)
+//	opts (models.GenerationOptions): Limits and sampling of the output
+//
+// Returns:
+//
+// None
+func SubMasks(stdIn *bufio.Scanner, infile string, doWeighted bool, doMultiByte bool, doDeHex bool, doNumberOfReplacements int, doFuzzAmount int, doMaxTokens int, opts models.GenerationOptions) {
+	args := utils.ConstructReplacements("ulds")
+	tokens := utils.CapTokensPerSlot(LoadTokenFile(infile, doWeighted), args, doMaxTokens)
+	writer := newCandidateWriter(opts)
+	lineNumber := int64(0)
 
 	var wg sync.WaitGroup
 
 	for stdIn.Scan() {
+		if writer.Full() {
+			break
+		}
+		lineNumber++
+
 		stringWord := ""
 		if utils.TestHexInput(stdIn.Text()) == true && doDeHex == true {
 			plaintext, err := utils.DehexPlaintext(stdIn.Text())
@@ -147,19 +387,40 @@ func SubMasks(stdIn *bufio.Scanner, infile string, doMultiByte bool, doDeHex boo
 			mask = models.EnsureValidMask(mask)
 		}
 
+		printCandidate, done := writer.Line(lineNumber)
 		wg.Add(1)
-		go func(stringWord string, mask string) {
+		go func(stringWord string, mask string, lineNumber int64) {
 			defer wg.Done()
-			for value := range tokens {
-				newWord := utils.ReplaceWordByMask(stringWord, mask, value, args, doNumberOfReplacements, doFuzzAmount)
+			defer done()
+			rng := rand.New(rand.NewSource(opts.Seed + lineNumber))
+			printed := 0
+
+			sampled := tokens
+			if indexes := utils.SampleIndexes(len(tokens), opts.Sample, rng); indexes != nil {
+				sampled = make([]models.WeightedToken, len(indexes))
+				for j, i := range indexes {
+					sampled[j] = tokens[i]
+				}
+			}
+
+			for _, token := range sampled {
+				newWord := utils.ReplaceWordByMask(stringWord, mask, token.Token, args, doNumberOfReplacements, doFuzzAmount)
 
 				if newWord != "" {
-					fmt.Println(newWord)
+					if !printCandidate(newWord) {
+						return
+					}
+
+					printed++
+					if printed == opts.MaxPerLine {
+						return
+					}
 				}
 			}
-		}(stringWord, mask)
+		}(stringWord, mask, lineNumber)
 	}
 	wg.Wait()
+	writer.Close()
 }
 
 // MutateMasks splits the input strings into chunks and replaces mask characters with the chunks
@@ -172,11 +433,15 @@ func SubMasks(stdIn *bufio.Scanner, infile string, doMultiByte bool, doDeHex boo
 //	doDeHex (bool): If $HEX[...] text should be processed
 //	doNumberOfReplacements (int): Max number of times to replace per string
 //	doFuzzAmount(int): Number of additional fuzz characters to add to replacer
+//	opts (models.GenerationOptions): Limits and sampling of the output
+//	doTwoPass (bool): If all tokens should be harvested before mutating
+//	tokenFile (string): File path to harvest tokens from instead of stdin
+//	doSpill (bool): If harvested tokens should be stored on disk
 //
 // Returns:
 //
 // None
-func MutateMasks(stdIn *bufio.Scanner, chunkSizeStr string, doMultiByte bool, doDeHex bool, doNumberOfReplacements int, doFuzzAmount int) {
+func MutateMasks(stdIn *bufio.Scanner, chunkSizeStr string, doMultiByte bool, doDeHex bool, doNumberOfReplacements int, doFuzzAmount int, opts models.GenerationOptions, doTwoPass bool, tokenFile string, doSpill bool) {
 	var tokens sync.Map
 	args := utils.ConstructReplacements("ulds")
 	stdText := ""
@@ -184,9 +449,24 @@ func MutateMasks(stdIn *bufio.Scanner, chunkSizeStr string, doMultiByte bool, do
 		CheckError(errors.New("Invalid Chunk Size"))
 	}
 
+	// Partitioned output needs every line to see the same tokens on every run
+	if doTwoPass || tokenFile != "" || doSpill || isPartitioned(opts) {
+		chunksInt, err := strconv.Atoi(chunkSizeStr)
+		CheckError(err)
+		mutateTwoPass(stdIn, chunksInt, doMultiByte, doDeHex, doNumberOfReplacements, doFuzzAmount, opts, tokenFile, doSpill)
+		return
+	}
+
+	writer := newCandidateWriter(opts)
+	lineNumber := int64(0)
+
 	var wg sync.WaitGroup
 
 	for stdIn.Scan() {
+		if writer.Full() {
+			break
+		}
+		lineNumber++
 
 		if utils.TestHexInput(stdIn.Text()) == true && doDeHex == true {
 			plaintext, err := utils.DehexPlaintext(stdIn.Text())
@@ -215,164 +495,367 @@ func MutateMasks(stdIn *bufio.Scanner, chunkSizeStr string, doMultiByte bool, do
 		}
 
 		wg.Add(1)
-		go func(stringWord string, mask string) {
+		go func(stringWord string, mask string, lineNumber int64) {
 			defer wg.Done()
+			rng := rand.New(rand.NewSource(opts.Seed + lineNumber))
+			printed := 0
 
-			tokens.Range(func(key, value interface{}) bool {
-				newWord := utils.ReplaceWordByMask(stringWord, mask, key.(string), args, doNumberOfReplacements, doFuzzAmount)
+			rangeTokenMap(&tokens, opts.Sample, rng, func(token string) bool {
+				newWord := utils.ReplaceWordByMask(stringWord, mask, token, args, doNumberOfReplacements, doFuzzAmount)
 				if newWord != "" {
-					fmt.Println(newWord)
+					if !writer.Print(newWord) {
+						return false
+					}
+
+					printed++
+					if printed == opts.MaxPerLine {
+						return false
+					}
 				}
 				return true
 			})
-		}(stringWord, mask)
+		}(stringWord, mask, lineNumber)
 	}
 	wg.Wait()
+	writer.Close()
 }
 
-// GenerateTokens generates tokens from the input strings by removing all non-alpha characters
+// mutateTwoPass harvests the complete token vocabulary before any mutation
+// starts so every line sees every token and output is reproducible
 //
 // Args:
 //
 //	stdIn (*bufio.Scanner): Buffer of standard input
-//	lengthStr (string): Length of the tokens as a number
+//	chunkSize (int): Minimum size of the tokens
+//	doMultiByte (bool): If multibyte text should be processed
 //	doDeHex (bool): If $HEX[...] text should be processed
+//	doNumberOfReplacements (int): Max number of times to replace per string
+//	doFuzzAmount(int): Number of additional fuzz characters to add to replacer
+//	opts (models.GenerationOptions): Limits and sampling of the output
+//	tokenFile (string): File path to harvest tokens from instead of stdin
+//	doSpill (bool): If harvested tokens should be stored on disk
 //
 // Returns:
 //
 // None
-func GenerateTokens(stdIn *bufio.Scanner, lengthStr string, doDeHex bool) {
-	stdText := ""
-	if models.IsStringInt(lengthStr) == false {
-		CheckError(errors.New("Invalid String Size"))
+func mutateTwoPass(stdIn *bufio.Scanner, chunkSize int, doMultiByte bool, doDeHex bool, doNumberOfReplacements int, doFuzzAmount int, opts models.GenerationOptions, tokenFile string, doSpill bool) {
+	args := utils.ConstructReplacements("ulds")
+	vocab := newTokenVocabulary(doSpill)
+	defer vocab.Remove()
+
+	// First pass harvests tokens from the token file or from stdin while
+	// buffering stdin to disk so it can be read again
+	inputs := stdIn
+	if tokenFile != "" {
+		buf, err := os.Open(tokenFile)
+		CheckError(err)
+		filescanner := bufio.NewScanner(buf)
+		for filescanner.Scan() {
+			vocab.Harvest(dehexLine(filescanner.Text(), doDeHex), chunkSize)
+		}
+		CheckError(filescanner.Err())
+		CheckError(buf.Close())
+	} else {
+		var remove func()
+		inputs, remove = bufferInput(stdIn, func(line string) {
+			vocab.Harvest(dehexLine(line, doDeHex), chunkSize)
+		})
+		defer remove()
 	}
+	vocab.Finish()
+
+	// Second pass mutates every line with the complete vocabulary
+	writer := newCandidateWriter(opts)
+	type job struct {
+		stringWord     string
+		mask           string
+		lineNumber     int64
+		printCandidate func(string) bool
+		done           func()
+	}
+	jobs := make(chan job)
 
-	for stdIn.Scan() {
-
-		if utils.TestHexInput(stdIn.Text()) == true && doDeHex == true {
-			plaintext, err := utils.DehexPlaintext(stdIn.Text())
-			if err != nil {
-				stdText = ""
-			}
-			stdText = plaintext
-		} else {
-			stdText = stdIn.Text()
-		}
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				rng := rand.New(rand.NewSource(opts.Seed + j.lineNumber))
+				printed := 0
+
+				vocab.Range(opts.Sample, rng, func(token string) bool {
+					newWord := utils.ReplaceWordByMask(j.stringWord, j.mask, token, args, doNumberOfReplacements, doFuzzAmount)
+					if newWord != "" {
+						if !j.printCandidate(newWord) {
+							return false
+						}
 
-		tokens := utils.MakeToken(stdText)
-		for _, token := range tokens {
-			if models.IsStringAlpha(token) == false {
-				continue
+						printed++
+						if printed == opts.MaxPerLine {
+							return false
+						}
+					}
+					return true
+				})
+				j.done()
 			}
+		}()
+	}
 
-			length, err := strconv.Atoi(lengthStr)
-			CheckError(err)
-
-			// NOTE: VALUES OVER 99 LET ALL THROUGH
-			if len(token) != length && length < 98 {
-				continue
-			}
+	lineNumber := int64(0)
+	for inputs.Scan() {
+		if writer.Full() {
+			break
+		}
+		lineNumber++
 
-			fmt.Printf("%s\n", token)
+		stringWord := dehexLine(inputs.Text(), doDeHex)
+		mask := utils.MakeMask(stringWord, args)
+		if doMultiByte {
+			mask = models.EnsureValidMask(mask)
 		}
+		printCandidate, done := writer.Line(lineNumber)
+		jobs <- job{stringWord: stringWord, mask: mask, lineNumber: lineNumber, printCandidate: printCandidate, done: done}
 	}
+	close(jobs)
+	wg.Wait()
+	writer.Close()
 }
 
-// GeneratePartialMasks generates partial masks from the input strings using the specified mask characters
+// bufferInput reads stdin to a temporary file so it can be read again after
+// a first pass
 //
 // Args:
 //
 //	stdIn (*bufio.Scanner): Buffer of standard input
-//	maskChars (string): String of which character sets to replace (udlsb)
-//	doDeHex (bool): If $HEX[...] text should be processed
+//	fn (func(string)): Function called with each line in the first pass
 //
 // Returns:
 //
-// None
-func GeneratePartialMasks(stdIn *bufio.Scanner, maskChars string, doDeHex bool) {
-	args := utils.ConstructReplacements(maskChars)
-	stdText := ""
-
-	if models.IsHashMask(maskChars) == false {
-		CheckError(errors.New("Can only contain 'u','d','l', 'b', and 's'"))
+//	(*bufio.Scanner): Scanner of the buffered input for the second pass
+//	(func()): Function that removes the temporary file
+func bufferInput(stdIn *bufio.Scanner, fn func(string)) (*bufio.Scanner, func()) {
+	buffer, err := os.CreateTemp("", "maskcat-input-*")
+	CheckError(err)
+	remove := func() {
+		buffer.Close()
+		os.Remove(buffer.Name())
 	}
 
+	bufWriter := bufio.NewWriter(buffer)
 	for stdIn.Scan() {
-
-		if utils.TestHexInput(stdIn.Text()) == true && doDeHex == true {
-			plaintext, err := utils.DehexPlaintext(stdIn.Text())
-			if err != nil {
-				stdText = ""
-			}
-			stdText = plaintext
-		} else {
-			stdText = stdIn.Text()
-		}
-
-		partial := utils.MakeMask(stdText, args)
-		if strings.Contains(maskChars, "b") {
-			partial = models.ConvertMultiByteString(partial)
-		}
-		fmt.Printf("%s\n", partial)
+		fn(stdIn.Text())
+		_, err = fmt.Fprintln(bufWriter, stdIn.Text())
+		CheckError(err)
 	}
+	CheckError(stdIn.Err())
+	CheckError(bufWriter.Flush())
+	_, err = buffer.Seek(0, 0)
+	CheckError(err)
+	return bufio.NewScanner(buffer), remove
 }
 
-// GeneratePartialRemoveMasks removes characters in masks from the input strings using the specified mask characters
+// tokenVocabulary holds a complete set of harvested tokens either in memory
+// or spilled to a file on disk
+type tokenVocabulary struct {
+	memory map[string]struct{}
+	tokens []string
+	sorter *extsort.Sorter
+	spill  *os.File
+	mapped []byte
+	unmap  func() error
+	count  int
+}
+
+// newTokenVocabulary creates an empty tokenVocabulary
+//
+// Spilled tokens are deduplicated with an external sort so memory stays
+// bounded and no token is lost.
 //
 // Args:
 //
-//	stdIn (*bufio.Scanner): Buffer of standard input
-//	infile (string): File path of input file to use
-//	maskChars (string): String of which character sets to replace (udlsb)
-//	doDeHex (bool): If $HEX[...] text should be processed
+//	doSpill (bool): If tokens should be stored on disk
 //
 // Returns:
 //
-// None
-func GeneratePartialRemoveMasks(stdIn *bufio.Scanner, maskChars string, doDeHex bool) {
-	args := utils.ConstructReplacements(maskChars)
-	stdText := ""
-
-	if models.IsHashMask(maskChars) == false {
-		CheckError(errors.New("Can only contain 'u','d','l', 'b', and 's'"))
-	}
-
-	for stdIn.Scan() {
-
-		if utils.TestHexInput(stdIn.Text()) == true && doDeHex == true {
-			plaintext, err := utils.DehexPlaintext(stdIn.Text())
-			if err != nil {
-				stdText = ""
-			}
-			stdText = plaintext
-		} else {
-			stdText = stdIn.Text()
-		}
-
-		partial := utils.MakeMask(stdText, args)
-		if strings.Contains(maskChars, "b") {
-			partial = models.ConvertMultiByteString(partial)
-		}
-		remaining := utils.RemoveMaskCharacters(partial)
-		fmt.Printf("%s\n", remaining)
+//	(*tokenVocabulary): Empty vocabulary
+func newTokenVocabulary(doSpill bool) *tokenVocabulary {
+	if !doSpill {
+		return &tokenVocabulary{memory: make(map[string]struct{})}
 	}
+	return &tokenVocabulary{sorter: extsort.NewSorter(0, "", true)}
 }
 
-// GenerateMasks generates masks from the input strings and prints information about the masks
+// Harvest adds the tokens of a string that are at least minLen long
+//
+// Args:
+//
+//	str (string): String to harvest tokens from
+//	minLen (int): Minimum length of tokens to keep
+//
+// Returns:
+//
+//	None
+func (v *tokenVocabulary) Harvest(str string, minLen int) {
+	for _, token := range utils.MakeToken(str) {
+		if len(token) < minLen || strings.Contains(token, "\n") {
+			continue
+		}
+
+		if v.sorter == nil {
+			v.memory[token] = struct{}{}
+			continue
+		}
+		CheckError(v.sorter.Add(token))
+	}
+}
+
+// Finish completes harvesting so the vocabulary can be ranged over
+//
+// Tokens are kept in byte order in memory and on disk so both give the same
+// output. Spilled tokens are written once to a file that is memory mapped.
+//
+// Returns:
+//
+//	None
+func (v *tokenVocabulary) Finish() {
+	if v.sorter == nil {
+		v.tokens = make([]string, 0, len(v.memory))
+		for token := range v.memory {
+			v.tokens = append(v.tokens, token)
+		}
+		sort.Strings(v.tokens)
+		v.memory = nil
+		v.count = len(v.tokens)
+		return
+	}
+
+	spill, err := os.CreateTemp("", "maskcat-tokens-*")
+	CheckError(err)
+	v.spill = spill
+
+	writer := bufio.NewWriter(spill)
+	CheckError(v.sorter.Each(func(token string) error {
+		v.count++
+		writer.WriteString(token)
+		return writer.WriteByte('\n')
+	}))
+	CheckError(writer.Flush())
+	v.sorter = nil
+
+	v.mapped, v.unmap, err = mapFile(spill)
+	CheckError(err)
+}
+
+// Range calls fn for each token or for a random sample of the tokens
+//
+// Tokens are read in place so ranging over spilled tokens only holds the
+// sampled indexes in memory.
+//
+// Args:
+//
+//	sample (int): Number of tokens to sample (0 uses all)
+//	rng (*rand.Rand): Random source to use
+//	fn (func(string) bool): Function to call, returning false stops iteration
+//
+// Returns:
+//
+//	None
+func (v *tokenVocabulary) Range(sample int, rng *rand.Rand, fn func(string) bool) {
+	indexes := utils.SampleIndexes(v.count, sample, rng)
+
+	if v.spill == nil {
+		if indexes == nil {
+			for _, token := range v.tokens {
+				if !fn(token) {
+					return
+				}
+			}
+			return
+		}
+		for _, i := range indexes {
+			if !fn(v.tokens[i]) {
+				return
+			}
+		}
+		return
+	}
+
+	// Every spilled token ends with a newline
+	data := v.mapped
+	for i, next := 0, 0; len(data) > 0 && (indexes == nil || next < len(indexes)); i++ {
+		end := bytes.IndexByte(data, '\n')
+		if indexes == nil || indexes[next] == i {
+			next++
+			if !fn(string(data[:end])) {
+				return
+			}
+		}
+		data = data[end+1:]
+	}
+}
+
+// Remove deletes any spilled tokens from disk
+//
+// Returns:
+//
+//	None
+func (v *tokenVocabulary) Remove() {
+	if v.sorter != nil {
+		v.sorter.Close()
+	}
+	if v.unmap != nil {
+		v.unmap()
+	}
+	if v.spill != nil {
+		v.spill.Close()
+		os.Remove(v.spill.Name())
+	}
+}
+
+// dehexLine decodes a $HEX[...] line when dehexing is enabled
+//
+// Args:
+//
+//	line (string): Line of input
+//	doDeHex (bool): If $HEX[...] text should be processed
+//
+// Returns:
+//
+//	(string): Decoded line or the original line
+func dehexLine(line string, doDeHex bool) string {
+	if utils.TestHexInput(line) == true && doDeHex == true {
+		plaintext, err := utils.DehexPlaintext(line)
+		if err != nil {
+			return ""
+		}
+		return plaintext
+	}
+	return line
+}
+
+// GenerateTokens generates tokens from the input strings and prints those
+// within the length bounds and of the selected character classes
 //
 // Args:
 //
 //	stdIn (*bufio.Scanner): Buffer of standard input
-//	doMultiByte (bool): If multibyte text should be processed
+//	minLen (int): Minimum length of the tokens
+//	maxLen (int): Maximum length of the tokens (0 allows all)
+//	classes (string): Comma separated list of token classes to print
 //	doDeHex (bool): If $HEX[...] text should be processed
-//	verbose (bool): If verbose stdText should be printed about masks
+//	layout (*keyboard.Layout): Layout to extract keyboard walks with instead (nil uses the tokenizer)
+//	walkLen (int): Minimum length of keyboard walks
+//	doSemantic (bool): If years, dates, months and seasons should be printed as kind=token instead
 //
 // Returns:
 //
 // None
-func GenerateMasks(stdIn *bufio.Scanner, doMultiByte bool, doDeHex bool, verbose bool) {
-	args := utils.ConstructReplacements("ulds")
+func GenerateTokens(stdIn *bufio.Scanner, minLen int, maxLen int, classes string, doDeHex bool, layout *keyboard.Layout, walkLen int, doSemantic bool) {
 	stdText := ""
+	keepToken := newTokenFilter(minLen, maxLen, classes)
+
 	for stdIn.Scan() {
 
 		if utils.TestHexInput(stdIn.Text()) == true && doDeHex == true {
@@ -385,133 +868,217 @@ func GenerateMasks(stdIn *bufio.Scanner, doMultiByte bool, doDeHex bool, verbose
 			stdText = stdIn.Text()
 		}
 
-		mask := utils.MakeMask(stdText, args)
-		if doMultiByte {
-			mask = models.EnsureValidMask(mask)
-		}
-		if verbose {
-			fmt.Printf("%s:%d:%d:%d\n", mask, len(stdText), utils.TestComplexity(mask), utils.TestEntropy(mask))
-		} else {
-			fmt.Printf("%s\n", mask)
+		tokens, labels := makeTokens(stdText, layout, walkLen, doSemantic)
+		for i, token := range tokens {
+			if keepToken(token) {
+				fmt.Printf("%s\n", labelToken(labels[i], token))
+			}
 		}
 	}
-
-	if err := stdIn.Err(); err != nil {
-		CheckError(err)
-	}
 }
 
-// GenerateTokenRetainMasks creates masks while retaining tokens from a file
+// CountTokens generates tokens from the input strings and prints the
+// frequency of each token as count:token sorted by count
 //
 // Args:
 //
 //	stdIn (*bufio.Scanner): Buffer of standard input
-//	infile (string): File path of input file to use
-//	doMultiByte (bool): If multibyte text should be processed
+//	minLen (int): Minimum length of the tokens
+//	maxLen (int): Maximum length of the tokens (0 allows all)
+//	classes (string): Comma separated list of token classes to print
+//	topN (int): Number of most frequent tokens to print (0 prints all)
+//	minCount (int): Minimum frequency of tokens to print
+//	approxSize (int): Number of tokens to track when approximating (0 counts exactly)
 //	doDeHex (bool): If $HEX[...] text should be processed
-//	doNumberOfReplacements (int): Max number of times to replace per string
+//	layout (*keyboard.Layout): Layout to extract keyboard walks with instead (nil uses the tokenizer)
+//	walkLen (int): Minimum length of keyboard walks
+//	doSemantic (bool): If years, dates, months and seasons should be counted as kind=token instead
 //
 // Returns:
 //
 // None
-func GenerateTokenRetainMasks(stdIn *bufio.Scanner, infile string, doMultiByte bool, doDeHex bool, doNumberOfReplacements int) {
-	buf, err := os.Open(infile)
-	CheckError(err)
-
-	defer func() {
-		if err = buf.Close(); err != nil {
-			fmt.Println(err)
-			os.Exit(0)
-		}
-	}()
-
-	filescanner := bufio.NewScanner(buf)
-	tokens := make(map[string]struct{})
-	args := utils.ConstructReplacements("ulds")
-
-	for filescanner.Scan() {
-		if filescanner.Text() != "" {
-			tokens[filescanner.Text()] = struct{}{}
-		}
+func CountTokens(stdIn *bufio.Scanner, minLen int, maxLen int, classes string, topN int, minCount int, approxSize int, doDeHex bool, layout *keyboard.Layout, walkLen int, doSemantic bool) {
+	stdText := ""
+	keepToken := newTokenFilter(minLen, maxLen, classes)
 
-		if err := filescanner.Err(); err != nil {
-			CheckError(err)
-		}
+	if topN < 0 || minCount < 0 || approxSize < 0 {
+		CheckError(errors.New("Invalid Count Option"))
 	}
 
-	var wg sync.WaitGroup
+	var tokenCounter counter.Counter
+	if approxSize > 0 {
+		tokenCounter = counter.NewSpaceSaving(approxSize)
+	} else {
+		tokenCounter = counter.NewExactCounter()
+	}
 
 	for stdIn.Scan() {
-		stringWord := ""
+
 		if utils.TestHexInput(stdIn.Text()) == true && doDeHex == true {
 			plaintext, err := utils.DehexPlaintext(stdIn.Text())
 			if err != nil {
-				stringWord = ""
+				stdText = ""
 			}
-			stringWord = plaintext
+			stdText = plaintext
 		} else {
-			stringWord = stdIn.Text()
+			stdText = stdIn.Text()
 		}
 
-		wg.Add(1)
-		go func(stringWord string) {
-			defer wg.Done()
+		// The tokenizer ends with every letter of the line joined together
+		// which is only counted when that text appears in the line and is
+		// not already one of the tokens
+		tokens, labels := makeTokens(stdText, layout, walkLen, doSemantic)
+		if layout == nil && !doSemantic && len(tokens) > 0 {
+			last := len(tokens) - 1
+			keep := strings.Contains(stdText, tokens[last])
+			for _, token := range tokens[:last] {
+				if token == tokens[last] {
+					keep = false
+					break
+				}
+			}
+			if !keep {
+				tokens = tokens[:last]
+			}
+		}
 
-			// Create the retain mask
-			mask := utils.CreateRetainMask(stringWord, tokens, args, doMultiByte, doNumberOfReplacements)
-			fmt.Println(mask)
+		for i, token := range tokens {
+			if keepToken(token) {
+				tokenCounter.Add(labelToken(labels[i], token))
+			}
+		}
+	}
 
-		}(stringWord)
+	if err := stdIn.Err(); err != nil {
+		CheckError(err)
+	}
+
+	for i, item := range tokenCounter.Items() {
+		if topN != 0 && i >= topN {
+			break
+		}
+
+		// Items are sorted so nothing after this point passes
+		if item.Count < minCount {
+			break
+		}
+		fmt.Printf("%d:%s\n", item.Count, item.Token)
 	}
-	wg.Wait()
 }
 
-// GenerateSpliceMutation performs mutation mode on retain masks
+// makeTokens splits a string into tokens with the tokenizer, into keyboard
+// walks when a layout is given or into semantic tokens
+//
+// A label is returned for each token which is the kind of semantic tokens and
+// empty otherwise.
+func makeTokens(str string, layout *keyboard.Layout, walkLen int, doSemantic bool) ([]string, []string) {
+	tokens := []string{}
+	switch {
+	case doSemantic:
+		labels := []string{}
+		for _, m := range semantic.Find(str) {
+			tokens = append(tokens, m.Token)
+			labels = append(labels, m.Kind)
+		}
+		return tokens, labels
+	case layout != nil:
+		tokens = layout.Walks(str, walkLen)
+	default:
+		tokens = utils.MakeToken(str)
+	}
+	return tokens, make([]string, len(tokens))
+}
+
+// labelToken prints a token as label:token when it has a label
+func labelToken(label string, token string) string {
+	if label == "" {
+		return token
+	}
+	return label + "=" + token
+}
+
+// LoadLayout looks up a keyboard layout for keyboard walk detection
 //
 // Args:
 //
-//	stdIn (*bufio.Scanner): Buffer of standard input
-//	infile (string): File path of input file to use
-//	doMultiByte (bool): If multibyte text should be processed
-//	doDeHex (bool): If $HEX[...] text should be processed
-//	doNumberOfReplacements (int): Max number of times to replace per string
-//	doFuzzAmount(int): Number of additional fuzz characters to add to replacer
+//	name (string): Name of the layout
+//	doWalk (bool): If keyboard walks should be detected
 //
 // Returns:
 //
-// None
-func GenerateSpliceMutation(stdIn *bufio.Scanner, infile string, doMultiByte bool, doDeHex bool, doNumberOfReplacements int, doFuzzAmount int) {
+//	(*keyboard.Layout): Keyboard layout or nil when walks are not detected
+func LoadLayout(name string, doWalk bool) *keyboard.Layout {
+	if !doWalk {
+		return nil
+	}
 
-	// Read the retain infile
-	buf, err := os.Open(infile)
+	layout, err := keyboard.Lookup(name)
 	CheckError(err)
+	return layout
+}
 
-	defer func() {
-		if err = buf.Close(); err != nil {
-			fmt.Println(err)
-			os.Exit(0)
+// newTokenFilter creates a function that tests if a token is within the
+// length bounds and of the selected character classes
+//
+// Args:
+//
+//	minLen (int): Minimum length of the tokens
+//	maxLen (int): Maximum length of the tokens (0 allows all)
+//	classes (string): Comma separated list of token classes to allow
+//
+// Returns:
+//
+//	(func(string) bool): Filter function returning true for allowed tokens
+func newTokenFilter(minLen int, maxLen int, classes string) func(string) bool {
+	allowed := make(map[string]struct{})
+	for _, class := range strings.Split(classes, ",") {
+		if models.IsTokenClass(class) == false {
+			CheckError(errors.New("Invalid Token Class"))
 		}
-	}()
+		allowed[class] = struct{}{}
+	}
 
-	filescanner := bufio.NewScanner(buf)
-	retainTokens := make(map[string]struct{})
-	args := utils.ConstructReplacements("ulds")
+	if minLen < 0 || maxLen < 0 || (maxLen != 0 && maxLen < minLen) {
+		CheckError(errors.New("Invalid Token Length"))
+	}
 
-	for filescanner.Scan() {
-		if filescanner.Text() != "" {
-			retainTokens[filescanner.Text()] = struct{}{}
+	return func(token string) bool {
+		// Lines without letters end with an empty joined token
+		if token == "" {
+			return false
+		}
+		if _, ok := allowed[utils.ClassifyToken(token)]; !ok {
+			return false
 		}
 
-		if err := filescanner.Err(); err != nil {
-			CheckError(err)
+		if len(token) < minLen || (maxLen != 0 && len(token) > maxLen) {
+			return false
 		}
+		return true
 	}
+}
 
-	// Start a mutation loop
-	var tokens sync.Map
+// GeneratePartialMasks generates partial masks from the input strings using the specified mask characters
+//
+// Args:
+//
+//	stdIn (*bufio.Scanner): Buffer of standard input
+//	maskChars (string): String of which character sets to replace (udlsb)
+//	doDeHex (bool): If $HEX[...] text should be processed
+//	doJohn (bool): If masks should be printed in John the Ripper syntax
+//	custom (map[byte]masks.Charset): Custom charsets keyed by '1' to '4'
+//	hexLen (int): Minimum length of hex runs replaced when using h or H
+//
+// Returns:
+//
+// None
+func GeneratePartialMasks(stdIn *bufio.Scanner, maskChars string, doDeHex bool, doJohn bool, custom map[byte]masks.Charset, hexLen int) {
+	args := utils.ConstructReplacements(maskChars)
 	stdText := ""
 
-	var wg sync.WaitGroup
+	if models.IsHashMask(maskChars) == false {
+		CheckError(errors.New("Can only contain 'u','d','l', 'b', 's', 'h', 'H' and 'a'"))
+	}
 
 	for stdIn.Scan() {
 
@@ -525,63 +1092,45 @@ func GenerateSpliceMutation(stdIn *bufio.Scanner, infile string, doMultiByte boo
 			stdText = stdIn.Text()
 		}
 
-		ngrams := utils.MakeToken(stdText)
-
-		for _, token := range ngrams {
-			if len(token) >= 4 {
-				tokens.Store(token, struct{}{})
+		partial := makePartialMask(stdText, maskChars, args, hexLen)
+		if strings.Contains(maskChars, "b") {
+			partial = models.ConvertMultiByteString(utils.MakeCustomMask(partial, custom))
+		}
+		if doJohn {
+			converted, err := utils.ConvertHashcatToJohn(partial, custom)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[SKIP] %s: %s\n", partial, err)
+				continue
 			}
+			partial = converted
+		} else {
+			partial = masks.EscapeHCMask(partial)
 		}
-
-		stringWord := stdText
-
-		wg.Add(1)
-		go func(stringWord string) {
-			defer wg.Done()
-
-			// Create the retain mask
-			mask := utils.CreateRetainMask(stringWord, retainTokens, args, doMultiByte, doNumberOfReplacements)
-
-			// Use the retain mask in mutation
-			tokens.Range(func(key, value interface{}) bool {
-				newWord := utils.ReplaceWordByMask(stringWord, mask, key.(string), args, doNumberOfReplacements, doFuzzAmount)
-
-				// Ensure results contain the retain tokens
-				if newWord != "" {
-					for value := range retainTokens {
-						if strings.Contains(newWord, value) {
-							fmt.Println(newWord)
-						}
-					}
-				}
-				return true
-			})
-		}(stringWord)
+		fmt.Printf("%s\n", partial)
 	}
-	wg.Wait()
 }
 
-// CalculateEntropy calculates the entropy of the input strings and only prints
-// those below the threshold
+// GeneratePartialRemoveMasks removes characters in masks from the input strings using the specified mask characters
 //
 // Args:
 //
 //	stdIn (*bufio.Scanner): Buffer of standard input
-//	threshold (string): Threshold to use for entropy
+//	infile (string): File path of input file to use
+//	maskChars (string): String of which character sets to replace (udlsb)
 //	doDeHex (bool): If $HEX[...] text should be processed
-//	verbose (bool): If verbose stdText should be printed about masks
+//	hexLen (int): Minimum length of hex runs removed when using h or H
 //
 // Returns:
 //
-//	None
-func CalculateEntropy(stdIn *bufio.Scanner, threshold string, doDeHex bool, verbose bool) {
-	if models.IsStringInt(threshold) == false {
-		CheckError(errors.New("Invalid Chunk Size"))
+// None
+func GeneratePartialRemoveMasks(stdIn *bufio.Scanner, maskChars string, doDeHex bool, hexLen int) {
+	args := utils.ConstructReplacements(maskChars)
+	stdText := ""
+
+	if models.IsHashMask(maskChars) == false {
+		CheckError(errors.New("Can only contain 'u','d','l', 'b', 's', 'h', 'H' and 'a'"))
 	}
-	thresholdInt, err := strconv.Atoi(threshold)
-	CheckError(err)
 
-	stdText := ""
 	for stdIn.Scan() {
 
 		if utils.TestHexInput(stdIn.Text()) == true && doDeHex == true {
@@ -594,15 +1143,1455 @@ func CalculateEntropy(stdIn *bufio.Scanner, threshold string, doDeHex bool, verb
 			stdText = stdIn.Text()
 		}
 
-		entropy := utils.TestEntropy(stdText)
-		if entropy < thresholdInt && entropy != 0 {
-			if verbose {
-				fmt.Printf("%s:%d\n", stdText, entropy)
-			} else {
-				fmt.Printf("%s\n", stdText)
-			}
+		partial := makePartialMask(stdText, maskChars, args, hexLen)
+		if strings.Contains(maskChars, "b") {
+			partial = models.ConvertMultiByteString(partial)
 		}
+		remaining := utils.RemoveMaskCharacters(partial)
+		fmt.Printf("%s\n", remaining)
+	}
+}
+
+// makePartialMask makes a partial mask using the hex detection pass when h
+// or H are selected and collapsing to ?a when a is selected
+func makePartialMask(str string, maskChars string, args []string, hexLen int) string {
+	partial := utils.MakeMask(str, args)
+	if strings.ContainsAny(maskChars, "hH") {
+		partial = utils.MakeHexMask(str, args, hexLen)
+	}
+	if strings.Contains(maskChars, "a") {
+		partial = utils.CollapseMask(partial)
 	}
+	return partial
+}
+
+// GenerateMasks generates masks from the input strings and prints information about the masks
+//
+// Args:
+//
+//	stdIn (*bufio.Scanner): Buffer of standard input
+//	doMultiByte (bool): If multibyte text should be processed
+//	doDeHex (bool): If $HEX[...] text should be processed
+//	verbose (bool): If verbose stdText should be printed about masks
+//	doJohn (bool): If masks should be printed in John the Ripper syntax
+//	custom (map[byte]masks.Charset): Custom charsets keyed by '1' to '4'
+//	hexLen (int): Minimum length of hex runs to print as ?h or ?H (0 disables)
+//	doCollapse (bool): If printable mask characters should be collapsed to ?a
+//	layout (*keyboard.Layout): Layout to only print masks of strings with keyboard walks (nil disables)
+//	walkLen (int): Minimum length of keyboard walks
+//	doInvert (bool): If only masks of strings without keyboard walks should be printed instead
+//	doSemantic (bool): If years and dates should be printed with custom charset hints
+//	doExpand (bool): If years, dates, months and seasons should be expanded into realistic values
+//	expandMax (int): Maximum number of expanded masks per string (0 prints all)
+//	opts (models.GenerationOptions): Limits and deduplication of expanded masks
+//
+// Returns:
+//
+// None
+func GenerateMasks(stdIn *bufio.Scanner, doMultiByte bool, doDeHex bool, verbose bool, doJohn bool, custom map[byte]masks.Charset, hexLen int, doCollapse bool, layout *keyboard.Layout, walkLen int, doInvert bool, doSemantic bool, doExpand bool, expandMax int, opts models.GenerationOptions) {
+	args := utils.ConstructReplacements("ulds")
+	stdText := ""
+
+	if doSemantic && !doExpand && len(custom) > 0 {
+		CheckError(errors.New("Custom charsets cannot be used with semantic hints"))
+	}
+
+	// Expanded masks repeat across strings so they are deduplicated unless
+	// another method is chosen
+	var writer *candidateWriter
+	if doExpand {
+		if expandMax < 0 {
+			CheckError(errors.New("Invalid Expand Option"))
+		}
+		quiet := opts.Dedupe == ""
+		if quiet {
+			opts.Dedupe = "exact"
+		}
+		writer = newCandidateWriter(opts)
+		writer.quiet = quiet
+		defer writer.Close()
+	}
+
+	for stdIn.Scan() {
+		if writer != nil && writer.Full() {
+			break
+		}
+
+		if utils.TestHexInput(stdIn.Text()) == true && doDeHex == true {
+			plaintext, err := utils.DehexPlaintext(stdIn.Text())
+			if err != nil {
+				stdText = ""
+			}
+			stdText = plaintext
+		} else {
+			stdText = stdIn.Text()
+		}
+
+		if layout != nil && layout.HasWalk(stdText, walkLen) == doInvert {
+			continue
+		}
+
+		emit := func(mask string) bool {
+			if doMultiByte {
+				mask = models.EnsureValidMask(mask)
+			}
+			if doCollapse {
+				mask = utils.CollapseMask(mask)
+			}
+			output := mask
+			if doJohn {
+				// Semantic hints are .hcmask lines so the charsets are parsed first
+				line, charsets, err := masks.ParseHCMaskLine(mask, custom)
+				if err == nil {
+					output, err = utils.ConvertHashcatToJohn(line, charsets)
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "[SKIP] %s: %s\n", mask, err)
+					return true
+				}
+			}
+			if verbose {
+				// Only the mask is scored and not the charsets of a .hcmask line
+				scored := mask
+				if line, _, err := masks.ParseHCMaskLine(mask, nil); err == nil {
+					scored = line
+				}
+				output = fmt.Sprintf("%s:%d:%d:%d", output, len(stdText), utils.TestComplexity(scored), utils.TestEntropy(scored))
+			}
+			if writer != nil {
+				return writer.Print(output)
+			}
+			fmt.Printf("%s\n", output)
+			return true
+		}
+
+		switch {
+		case doExpand:
+			utils.ExpandSemanticMask(stdText, args, expandMax, func(mask string) bool {
+				return emit(utils.MakeCustomMask(mask, custom))
+			})
+		case doSemantic:
+			emit(utils.MakeSemanticMask(stdText, args))
+		case hexLen > 0:
+			emit(utils.MakeCustomMask(utils.MakeHexMask(stdText, args, hexLen), custom))
+		default:
+			emit(utils.MakeCustomMask(utils.MakeMask(stdText, args), custom))
+		}
+	}
+
+	if err := stdIn.Err(); err != nil {
+		CheckError(err)
+	}
+}
+
+// GenerateTokenRetainMasks creates masks while retaining tokens from a file
+//
+// Args:
+//
+//	stdIn (*bufio.Scanner): Buffer of standard input
+//	infile (string): File path of input file to use
+//	doWeighted (bool): If the token file has count:token or token\tweight lines
+//	doMultiByte (bool): If multibyte text should be processed
+//	doDeHex (bool): If $HEX[...] text should be processed
+//	doNumberOfReplacements (int): Max number of times to replace per string
+//	layout (*keyboard.Layout): Layout to retain keyboard walks from each string with (nil disables)
+//	walkLen (int): Minimum length of keyboard walks
+//
+// Returns:
+//
+// None
+func GenerateTokenRetainMasks(stdIn *bufio.Scanner, infile string, doWeighted bool, doMultiByte bool, doDeHex bool, doNumberOfReplacements int, layout *keyboard.Layout, walkLen int) {
+	tokens := []models.WeightedToken{}
+	if infile != "" {
+		tokens = LoadTokenFile(infile, doWeighted)
+	}
+	args := utils.ConstructReplacements("ulds")
+
+	var wg sync.WaitGroup
+
+	for stdIn.Scan() {
+		stringWord := ""
+		if utils.TestHexInput(stdIn.Text()) == true && doDeHex == true {
+			plaintext, err := utils.DehexPlaintext(stdIn.Text())
+			if err != nil {
+				stringWord = ""
+			}
+			stringWord = plaintext
+		} else {
+			stringWord = stdIn.Text()
+		}
+
+		wg.Add(1)
+		go func(stringWord string) {
+			defer wg.Done()
+
+			// Keyboard walks in the string are retained along with the file tokens
+			retainTokens := tokens
+			if layout != nil {
+				if walks := layout.Walks(stringWord, walkLen); len(walks) > 0 {
+					retainTokens = append([]models.WeightedToken{}, tokens...)
+					for _, walk := range walks {
+						retainTokens = append(retainTokens, models.WeightedToken{Token: walk, Weight: 1})
+					}
+				}
+			}
+
+			// Create the retain mask
+			mask := utils.CreateRetainMask(stringWord, retainTokens, args, doMultiByte, doNumberOfReplacements)
+			fmt.Println(masks.EscapeHCMask(mask))
+
+		}(stringWord)
+	}
+	wg.Wait()
+}
+
+// CombineTokens prints candidates made by filling the slots of a template
+// with tokens
+//
+// Lines of the token files are split with utils.MakeToken and pooled so a
+// slot can be filled by a token from any file. Tokens are used in order of
+// weight and then file order. The separator is placed between tokens of
+// adjacent slots.
+//
+// Args:
+//
+//	template (string): Template such as ?w?d?d?d?d?s
+//	infiles ([]string): File paths of token files to use
+//	doWeighted (bool): If the token files have count:token or token\tweight lines
+//	sep (string): Separator placed between adjacent tokens
+//	minLen (int): Minimum length of candidates to print
+//	maxLen (int): Maximum length of candidates to print (0 allows all)
+//	opts (models.GenerationOptions): Limits of the output
+//
+// Returns:
+//
+//	None
+func CombineTokens(template string, infiles []string, doWeighted bool, sep string, minLen int, maxLen int, opts models.GenerationOptions) {
+	parts, err := utils.SplitTemplate(template)
+	if err != nil {
+		CheckError(fmt.Errorf("Invalid Template: %w", err))
+	}
+	if minLen < 0 || maxLen < 0 {
+		CheckError(errors.New("Invalid Length"))
+	}
+
+	pool := []string{}
+	seen := make(map[string]struct{})
+	for _, infile := range infiles {
+		for _, token := range LoadTokenFile(infile, doWeighted) {
+			for _, piece := range utils.MakeToken(token.Token) {
+				if _, exists := seen[piece]; !exists {
+					seen[piece] = struct{}{}
+					pool = append(pool, piece)
+				}
+			}
+		}
+	}
+
+	slots := make([][]string, len(parts))
+	for i, part := range parts {
+		if !part.Slot {
+			continue
+		}
+		for _, token := range pool {
+			if utils.FitsSlot(token, part.Text) {
+				slots[i] = append(slots[i], token)
+			}
+		}
+	}
+
+	writer := newCandidateWriter(opts)
+	defer writer.Close()
+
+	var combine func(i int, candidate []byte) bool
+	combine = func(i int, candidate []byte) bool {
+		if maxLen > 0 && len(candidate) > maxLen {
+			return true
+		}
+		if i == len(parts) {
+			if len(candidate) >= minLen {
+				return writer.Print(string(candidate))
+			}
+			return true
+		}
+
+		if !parts[i].Slot {
+			return combine(i+1, append(candidate, parts[i].Text...))
+		}
+		if i > 0 && parts[i-1].Slot {
+			candidate = append(candidate, sep...)
+		}
+		for _, token := range slots[i] {
+			if !combine(i+1, append(candidate, token...)) {
+				return false
+			}
+		}
+		return true
+	}
+	combine(0, []byte{})
+}
+
+// GeneratePrince prints candidates made by chaining elements in order of
+// probability
+//
+// Elements are read from a token file or harvested from stdin with
+// utils.MakeToken where each element is weighted by how often it was seen.
+// Chains of elements are printed from the most to least probable until every
+// chain with a length within the bounds has been printed.
+//
+// Args:
+//
+//	stdIn (*bufio.Scanner): Buffer of standard input
+//	infile (string): File path of a token file to use (empty harvests stdin)
+//	doWeighted (bool): If the token file has count:token or token\tweight lines
+//	doMultiByte (bool): If multibyte text should be processed
+//	doDeHex (bool): If $HEX[...] text should be processed
+//	minLen (int): Minimum length of candidates
+//	maxLen (int): Maximum length of candidates (0 uses prince.DefaultMaxLength)
+//	maxElements (int): Maximum number of elements in a chain
+//	opts (models.GenerationOptions): Limits and partitioning of the output
+//
+// Returns:
+//
+//	None
+func GeneratePrince(stdIn *bufio.Scanner, infile string, doWeighted bool, doMultiByte bool, doDeHex bool, minLen int, maxLen int, maxElements int, opts models.GenerationOptions) {
+	if maxLen == 0 {
+		maxLen = prince.DefaultMaxLength
+	}
+	if minLen < 0 || maxLen < minLen || maxElements < 1 {
+		CheckError(errors.New("Invalid Prince Option"))
+	}
+
+	elements := prince.NewElements()
+	if infile != "" {
+		for _, token := range LoadTokenFile(infile, doWeighted) {
+			elements.Add(token.Token, token.Weight)
+		}
+	} else {
+		for stdIn.Scan() {
+			stdText := dehexLine(stdIn.Text(), doDeHex)
+			if !doMultiByte && !models.IsStringASCII(stdText) {
+				continue
+			}
+			for _, token := range utils.MakeToken(stdText) {
+				elements.Add(token, 1)
+			}
+		}
+		if err := stdIn.Err(); err != nil {
+			CheckError(err)
+		}
+	}
+
+	// The keyspace is calculated as counting could take too long
+	if opts.Keyspace {
+		fmt.Println(elements.Keyspace(minLen, maxLen, maxElements).String())
+		return
+	}
+
+	writer := newCandidateWriter(opts)
+	defer writer.Close()
+
+	queue := elements.Queue(minLen, maxLen, maxElements)
+	for guess, ok := queue.Next(); ok; guess, ok = queue.Next() {
+		if !writer.Print(guess.Value) {
+			break
+		}
+	}
+}
+
+// GenerateSpliceMutation performs mutation mode on retain masks
+//
+// Args:
+//
+//	stdIn (*bufio.Scanner): Buffer of standard input
+//	infile (string): File path of input file to use
+//	doWeighted (bool): If the token file has count:token or token\tweight lines
+//	doMultiByte (bool): If multibyte text should be processed
+//	doDeHex (bool): If $HEX[...] text should be processed
+//	doNumberOfReplacements (int): Max number of times to replace per string
+//	doFuzzAmount(int): Number of additional fuzz characters to add to replacer
+//	opts (models.GenerationOptions): Limits and sampling of the output
+//
+// Returns:
+//
+// None
+func GenerateSpliceMutation(stdIn *bufio.Scanner, infile string, doWeighted bool, doMultiByte bool, doDeHex bool, doNumberOfReplacements int, doFuzzAmount int, opts models.GenerationOptions) {
+
+	// Read the retain infile
+	retainTokens := LoadTokenFile(infile, doWeighted)
+	args := utils.ConstructReplacements("ulds")
+
+	// Start a mutation loop
+	var tokens sync.Map
+	stdText := ""
+	writer := newCandidateWriter(opts)
+	lineNumber := int64(0)
+
+	// Partitioned output harvests every token first so every line sees the
+	// same tokens on every run
+	inputs := stdIn
+	rangeTokens := func(rng *rand.Rand, fn func(string) bool) {
+		rangeTokenMap(&tokens, opts.Sample, rng, fn)
+	}
+	if isPartitioned(opts) {
+		vocab := newTokenVocabulary(false)
+		var remove func()
+		inputs, remove = bufferInput(stdIn, func(line string) {
+			vocab.Harvest(dehexLine(line, doDeHex), 4)
+		})
+		defer remove()
+		vocab.Finish()
+		rangeTokens = func(rng *rand.Rand, fn func(string) bool) {
+			vocab.Range(opts.Sample, rng, fn)
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	for inputs.Scan() {
+		if writer.Full() {
+			break
+		}
+		lineNumber++
+
+		if utils.TestHexInput(inputs.Text()) == true && doDeHex == true {
+			plaintext, err := utils.DehexPlaintext(inputs.Text())
+			if err != nil {
+				stdText = ""
+			}
+			stdText = plaintext
+		} else {
+			stdText = inputs.Text()
+		}
+
+		if !isPartitioned(opts) {
+			ngrams := utils.MakeToken(stdText)
+
+			for _, token := range ngrams {
+				if len(token) >= 4 {
+					tokens.Store(token, struct{}{})
+				}
+			}
+		}
+
+		stringWord := stdText
+
+		printCandidate, done := writer.Line(lineNumber)
+		wg.Add(1)
+		go func(stringWord string, lineNumber int64) {
+			defer wg.Done()
+			defer done()
+			rng := rand.New(rand.NewSource(opts.Seed + lineNumber))
+			printed := 0
+
+			// Create the retain mask
+			mask := utils.CreateRetainMask(stringWord, retainTokens, args, doMultiByte, doNumberOfReplacements)
+
+			// Use the retain mask in mutation
+			rangeTokens(rng, func(token string) bool {
+				newWord := utils.ReplaceWordByMask(stringWord, mask, token, args, doNumberOfReplacements, doFuzzAmount)
+
+				// Ensure results contain the retain tokens
+				if newWord != "" {
+					for _, value := range retainTokens {
+						if strings.Contains(newWord, value.Token) {
+							if !printCandidate(newWord) {
+								return false
+							}
+
+							printed++
+							if printed == opts.MaxPerLine {
+								return false
+							}
+							break
+						}
+					}
+				}
+				return true
+			})
+		}(stringWord, lineNumber)
+	}
+	wg.Wait()
+	writer.Close()
+}
+
+// GenerateStructures prints the base structure of the input strings and
+// writes the learned grammar to a file
+//
+// Args:
+//
+//	stdIn (*bufio.Scanner): Buffer of standard input
+//	outfile (string): File path to write the grammar to
+//	doMultiByte (bool): If multibyte text should be processed
+//	doDeHex (bool): If $HEX[...] text should be processed
+//	doCount (bool): If structures should be printed as count:structure instead
+//
+// Returns:
+//
+//	None
+func GenerateStructures(stdIn *bufio.Scanner, outfile string, doMultiByte bool, doDeHex bool, doCount bool) {
+	grammar := pcfg.NewGrammar()
+
+	for stdIn.Scan() {
+		stdText := dehexLine(stdIn.Text(), doDeHex)
+		if stdText == "" || (!doMultiByte && !models.IsStringASCII(stdText)) {
+			continue
+		}
+
+		grammar.Add(stdText)
+		if !doCount {
+			structure, _, _ := pcfg.Parse(stdText)
+			fmt.Println(structure)
+		}
+	}
+
+	if err := stdIn.Err(); err != nil {
+		CheckError(err)
+	}
+
+	if doCount {
+		for _, e := range pcfg.Sorted(grammar.Structures) {
+			fmt.Printf("%d:%s\n", e.Count, e.Value)
+		}
+	}
+
+	file, err := os.Create(outfile)
+	CheckError(err)
+	CheckError(grammar.Write(file))
+	CheckError(file.Close())
+}
+
+// GenerateMarkov learns per-position Markov statistics from stdin and
+// writes them to an .hcstat2 file
+//
+// Args:
+//
+//	stdIn (*bufio.Scanner): Buffered standard input scanner
+//	outfile (string): File path to write the .hcstat2 file to
+//	doMultiByte (bool): If multibyte text should be processed
+//	doDeHex (bool): If $HEX[...] text should be processed
+//
+// Returns:
+//
+//	None
+func GenerateMarkov(stdIn *bufio.Scanner, outfile string, doMultiByte bool, doDeHex bool) {
+	stats := markov.NewStats()
+
+	for stdIn.Scan() {
+		stdText := dehexLine(stdIn.Text(), doDeHex)
+		if stdText == "" || (!doMultiByte && !models.IsStringASCII(stdText)) {
+			continue
+		}
+		stats.Add(stdText)
+	}
+
+	if err := stdIn.Err(); err != nil {
+		CheckError(err)
+	}
+
+	file, err := os.Create(outfile)
+	CheckError(err)
+	CheckError(stats.Write(file))
+	CheckError(file.Close())
+}
+
+// LoadModel reads or learns a model used to score candidates
+//
+// The "mask" model is learned from a file of training strings. The "markov"
+// model is read from an .hcstat2 file or learned from training strings.
+//
+// Args:
+//
+//	infile (string): File path of training strings or an .hcstat2 file
+//	model (string): Name of the model (mask, markov)
+//	doMultiByte (bool): If multibyte training strings should be processed
+//	doDeHex (bool): If $HEX[...] training strings should be processed
+//
+// Returns:
+//
+//	(score.Model): Model
+func LoadModel(infile string, model string, doMultiByte bool, doDeHex bool) score.Model {
+	var m score.Model
+	switch model {
+	case "mask":
+		m = score.NewMaskModel()
+	case "markov":
+		if stats, ok := readMarkov(infile); ok {
+			return stats
+		}
+		m = markov.NewStats()
+	default:
+		CheckError(fmt.Errorf("Invalid Model %s", model))
+	}
+
+	buf, err := os.Open(infile)
+	CheckError(err)
+	defer buf.Close()
+
+	filescanner := bufio.NewScanner(buf)
+	for filescanner.Scan() {
+		line := dehexLine(filescanner.Text(), doDeHex)
+		if doMultiByte || models.IsStringASCII(line) {
+			m.Add(line)
+		}
+	}
+	CheckError(filescanner.Err())
+	return m
+}
+
+// readMarkov reads an .hcstat2 file if the file starts like an LZMA2 stream
+func readMarkov(infile string) (*markov.Stats, bool) {
+	buf, err := os.Open(infile)
+	CheckError(err)
+	defer buf.Close()
+
+	// LZMA2 streams start with a chunk that resets the dictionary
+	reader := bufio.NewReader(buf)
+	first, err := reader.Peek(1)
+	if err != nil || (first[0] != 0x01 && first[0] < 0xe0) {
+		return nil, false
+	}
+
+	stats, err := markov.ReadStats(reader)
+	return stats, err == nil
+}
+
+// ScoreCandidates prints each line of stdin with its log probability as
+// score:candidate
+//
+// Args:
+//
+//	stdIn (*bufio.Scanner): Buffered standard input scanner
+//	infile (string): File path of training strings or an .hcstat2 file
+//	model (string): Name of the model (mask, markov)
+//	doMultiByte (bool): If multibyte text should be processed
+//	doDeHex (bool): If $HEX[...] text should be processed
+//	doSort (bool): If output should be sorted from the highest score
+//	doUnique (bool): If duplicate lines should be removed when sorting
+//	memory (int): Bytes of lines to hold in memory when sorting
+//	tmpDir (string): Directory for temporary files when sorting
+//
+// Returns:
+//
+//	None
+func ScoreCandidates(stdIn *bufio.Scanner, infile string, model string, doMultiByte bool, doDeHex bool, doSort bool, doUnique bool, memory int, tmpDir string) {
+	m := LoadModel(infile, model, doMultiByte, doDeHex)
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	// Sorted lines are prefixed with a key that orders them by score
+	sorter := extsort.NewSorter(memory, tmpDir, doUnique)
+	defer sorter.Close()
+
+	for stdIn.Scan() {
+		stdText := dehexLine(stdIn.Text(), doDeHex)
+		if !doMultiByte && !models.IsStringASCII(stdText) {
+			continue
+		}
+
+		value := m.Score(stdText)
+		line := score.Format(value) + ":" + stdText
+		if doSort || doUnique {
+			CheckError(sorter.Add(score.Key(value) + line))
+		} else {
+			out.WriteString(line)
+			out.WriteByte('\n')
+		}
+	}
+
+	if err := stdIn.Err(); err != nil {
+		CheckError(err)
+	}
+
+	if doSort || doUnique {
+		CheckError(sorter.Each(func(line string) error {
+			out.WriteString(line[score.KeyLength:])
+			return out.WriteByte('\n')
+		}))
+	}
+}
+
+// SortLines prints the lines of stdin in byte order
+//
+// Lines are only split on newlines so every byte of a line is kept. Lines
+// that do not fit in memory are sorted in temporary files and merged.
+//
+// Args:
+//
+//	stdIn (io.Reader): Standard input
+//	doUnique (bool): If duplicate lines should be removed
+//	memory (int): Bytes of lines to hold in memory
+//	tmpDir (string): Directory for temporary files
+//
+// Returns:
+//
+//	None
+func SortLines(stdIn io.Reader, doUnique bool, memory int, tmpDir string) {
+	sorter := extsort.NewSorter(memory, tmpDir, doUnique)
+	defer sorter.Close()
+
+	CheckError(sorter.AddLines(stdIn))
+	printSorted(os.Stdout, sorter)
+}
+
+// RankLines prints the distinct lines of stdin from the most to least
+// frequent
+//
+// Lines are counted by sorting them so equal lines are next to each other and
+// the counts are then sorted again. Both sorts use temporary files when the
+// lines do not fit in memory. Lines with the same count are printed in byte
+// order.
+//
+// Args:
+//
+//	stdIn (io.Reader): Standard input
+//	verbose (bool): If lines should be printed as count:line
+//	minCount (int): Minimum count of lines to print
+//	top (int): Max number of lines to print (0 prints all)
+//	memory (int): Bytes of lines to hold in memory
+//	tmpDir (string): Directory for temporary files
+//
+// Returns:
+//
+//	None
+func RankLines(stdIn io.Reader, verbose bool, minCount int, top int, memory int, tmpDir string) {
+	if minCount < 0 || top < 0 {
+		CheckError(errors.New("Invalid Rank Option"))
+	}
+
+	lines := extsort.NewSorter(memory, tmpDir, false)
+	defer lines.Close()
+	CheckError(lines.AddLines(stdIn))
+
+	// Counted lines are prefixed with a key that orders them by count
+	counts := extsort.NewSorter(memory, tmpDir, false)
+	defer counts.Close()
+	addCount := func(line string, count int) error {
+		if count < minCount {
+			return nil
+		}
+		return counts.Add(fmt.Sprintf("%016x%d:%s", ^uint64(count), count, line))
+	}
+
+	last, count := "", 0
+	CheckError(lines.Each(func(line string) error {
+		if count > 0 && line == last {
+			count++
+			return nil
+		}
+		if count > 0 {
+			if err := addCount(last, count); err != nil {
+				return err
+			}
+		}
+		last, count = line, 1
+		return nil
+	}))
+	if count > 0 {
+		CheckError(addCount(last, count))
+	}
+
+	out := bufio.NewWriterSize(os.Stdout, 1<<16)
+	printed := 0
+	errTop := errors.New("top reached")
+	err := counts.Each(func(line string) error {
+		if top > 0 && printed == top {
+			return errTop
+		}
+		printed++
+
+		line = line[16:]
+		if !verbose {
+			line = line[strings.IndexByte(line, ':')+1:]
+		}
+		out.WriteString(line)
+		return out.WriteByte('\n')
+	})
+	if err != errTop {
+		CheckError(err)
+	}
+	CheckError(out.Flush())
+}
+
+// SortOutput sorts everything a mode prints to stdout
+//
+// Stdout is replaced with a pipe that is read into a sorter. The returned
+// function must be called when the mode is done to print the sorted lines.
+// If the sorter fails, such as when the temporary directory is full, the
+// error is printed and the program exits instead of blocking the mode.
+//
+// Args:
+//
+//	doUnique (bool): If duplicate lines should be removed
+//	memory (int): Bytes of lines to hold in memory
+//	tmpDir (string): Directory for temporary files
+//
+// Returns:
+//
+//	(func()): Function that prints the sorted lines
+func SortOutput(doUnique bool, memory int, tmpDir string) func() {
+	sorter := extsort.NewSorter(memory, tmpDir, doUnique)
+	reader, writer, err := os.Pipe()
+	CheckError(err)
+
+	stdout := os.Stdout
+	os.Stdout = writer
+	done := make(chan struct{})
+	go func() {
+		// Nothing drains the pipe after an error so the mode would block
+		if err := sorter.AddLines(reader); err != nil {
+			sorter.Close()
+			fmt.Fprintf(stdout, "ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		close(done)
+	}()
+
+	return func() {
+		CheckError(writer.Close())
+		<-done
+		os.Stdout = stdout
+		printSorted(stdout, sorter)
+	}
+}
+
+// printSorted prints the lines of a sorter and empties it
+func printSorted(w io.Writer, sorter *extsort.Sorter) {
+	out := bufio.NewWriterSize(w, 1<<16)
+	CheckError(sorter.Each(func(line string) error {
+		out.WriteString(line)
+		return out.WriteByte('\n')
+	}))
+	CheckError(out.Flush())
+}
+
+// LoadGrammar reads a grammar file or learns a grammar from a file of
+// training strings
+//
+// Args:
+//
+//	infile (string): File path of a grammar file or training strings
+//	doMultiByte (bool): If multibyte training strings should be processed
+//	doDeHex (bool): If $HEX[...] training strings should be processed
+//
+// Returns:
+//
+//	(*pcfg.Grammar): Grammar
+func LoadGrammar(infile string, doMultiByte bool, doDeHex bool) *pcfg.Grammar {
+	buf, err := os.Open(infile)
+	CheckError(err)
+	defer buf.Close()
+
+	reader := bufio.NewReader(buf)
+	header, _ := reader.Peek(len(pcfg.GrammarHeader))
+	if string(header) == pcfg.GrammarHeader {
+		grammar, err := pcfg.ReadGrammar(reader)
+		CheckError(err)
+		return grammar
+	}
+
+	grammar := pcfg.NewGrammar()
+	filescanner := bufio.NewScanner(reader)
+	for filescanner.Scan() {
+		line := dehexLine(filescanner.Text(), doDeHex)
+		if doMultiByte || models.IsStringASCII(line) {
+			grammar.Add(line)
+		}
+	}
+	CheckError(filescanner.Err())
+	return grammar
+}
+
+// GenerateCandidates prints candidates from a grammar in descending
+// probability
+//
+// When a checkpoint file is given the number of candidates generated is saved
+// to it as output is written and when the mode exits. If the file already
+// exists that many candidates are skipped first so an interrupted run can be
+// resumed. The saved count never runs ahead of the output so a resumed run
+// can repeat a few candidates but never misses one. Skip and shard count
+// from the start of the grammar so resumed runs stay in their partition.
+//
+// Args:
+//
+//	infile (string): File path of a grammar file or training strings
+//	doMultiByte (bool): If multibyte training strings should be processed
+//	doDeHex (bool): If $HEX[...] training strings should be processed
+//	checkpoint (string): File path of the checkpoint file (empty disables)
+//	opts (models.GenerationOptions): Limits and partitioning of the output
+//
+// Returns:
+//
+//	None
+func GenerateCandidates(infile string, doMultiByte bool, doDeHex bool, checkpoint string, opts models.GenerationOptions) {
+	// The keyspace is calculated as counting could take too long
+	bases := LoadGrammar(infile, doMultiByte, doDeHex).Bases()
+	if opts.Keyspace {
+		fmt.Println(pcfg.Keyspace(bases).String())
+		return
+	}
+
+	writer := newCandidateWriter(opts)
+	queue := pcfg.NewQueue(bases)
+	done := readCheckpoint(checkpoint)
+	for i := 0; i < done; i++ {
+		if _, ok := queue.Next(); !ok {
+			break
+		}
+	}
+	// Grammar guesses are unique so the position matches the index
+	writer.index = int64(done)
+	writer.position = int64(done)
+
+	save := func() {
+		writer.mu.Lock()
+		defer writer.mu.Unlock()
+		if writer.out.Flush() == nil {
+			writeCheckpoint(checkpoint, int(writer.index))
+		}
+	}
+
+	// The checkpoint is saved when interrupted so the run can be resumed
+	if checkpoint != "" {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			save()
+			os.Exit(1)
+		}()
+	}
+
+	for i := 1; ; i++ {
+		guess, ok := queue.Next()
+		if !ok || !writer.Print(guess.Value) {
+			break
+		}
+		if i%100000 == 0 {
+			save()
+		}
+	}
+	save()
+	writer.Close()
+}
+
+// readCheckpoint reads the number of candidates saved in a checkpoint file
+func readCheckpoint(checkpoint string) int {
+	if checkpoint == "" {
+		return 0
+	}
+
+	data, err := os.ReadFile(checkpoint)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	CheckError(err)
+
+	done, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || done < 0 {
+		CheckError(fmt.Errorf("Invalid Checkpoint File %s", checkpoint))
+	}
+	return done
+}
+
+// writeCheckpoint saves the number of candidates printed to a checkpoint
+// file
+func writeCheckpoint(checkpoint string, done int) {
+	if checkpoint == "" {
+		return
+	}
+	CheckError(os.WriteFile(checkpoint, []byte(strconv.Itoa(done)+"\n"), 0o644))
+}
+
+// CalculateEntropy calculates the entropy of the input strings and only prints
+// those below the threshold
+//
+// Args:
+//
+//	stdIn (*bufio.Scanner): Buffer of standard input
+//	threshold (string): Threshold to use for entropy
+//	doDeHex (bool): If $HEX[...] text should be processed
+//	verbose (bool): If verbose stdText should be printed about masks
+//
+// Returns:
+//
+//	None
+func CalculateEntropy(stdIn *bufio.Scanner, threshold string, doDeHex bool, verbose bool) {
+	if models.IsStringInt(threshold) == false {
+		CheckError(errors.New("Invalid Chunk Size"))
+	}
+	thresholdInt, err := strconv.Atoi(threshold)
+	CheckError(err)
+
+	stdText := ""
+	for stdIn.Scan() {
+
+		if utils.TestHexInput(stdIn.Text()) == true && doDeHex == true {
+			plaintext, err := utils.DehexPlaintext(stdIn.Text())
+			if err != nil {
+				stdText = ""
+			}
+			stdText = plaintext
+		} else {
+			stdText = stdIn.Text()
+		}
+
+		entropy := utils.TestEntropy(stdText)
+		if entropy < thresholdInt && entropy != 0 {
+			if verbose {
+				fmt.Printf("%s:%d\n", stdText, entropy)
+			} else {
+				fmt.Printf("%s\n", stdText)
+			}
+		}
+	}
+}
+
+// candidateWriter prints generated candidates and enforces the global output
+// limit, partitioning and deduplication across goroutines
+//
+// Candidates pass through deduplication, then skip, then shard and then the
+// limit. Skip and shard count the candidates left after deduplication so
+// they partition the same stream of unique candidates on every machine and
+// no candidate is printed by two shards. Deduplication then sees every
+// candidate on every machine so each machine needs memory for the whole
+// stream. When the stream is partitioned or
+// limited the candidates of each input line are printed in line order so the
+// stream is the same on every run.
+type candidateWriter struct {
+	limit      int64
+	skip       int64
+	shard      int64
+	shards     int64
+	keyspace   bool
+	index      int64
+	position   int64
+	printed    int64
+	generated  int64
+	duplicates int64
+	seen       dedupe.Set
+	quiet      bool
+	mu         sync.Mutex
+	out        *bufio.Writer
+	ordered    bool
+	nextLine   int64
+	pending    map[int64]*lineBuffer
+	inFlight   chan struct{}
+}
+
+// newCandidateWriter creates a candidateWriter from generation options
+//
+// Args:
+//
+//	opts (models.GenerationOptions): Options to use for output
+//
+// Returns:
+//
+//	(*candidateWriter): Writer for candidates
+func newCandidateWriter(opts models.GenerationOptions) *candidateWriter {
+	if opts.Limit < 0 || opts.MaxPerLine < 0 || opts.Sample < 0 || opts.Skip < 0 {
+		CheckError(errors.New("Invalid Generation Option"))
+	}
+	if opts.Shards > 0 && (opts.Shard < 1 || opts.Shard > opts.Shards) {
+		CheckError(errors.New("Invalid Shard"))
+	}
+
+	writer := &candidateWriter{
+		limit:    int64(opts.Limit),
+		skip:     int64(opts.Skip),
+		shard:    int64(opts.Shard),
+		shards:   int64(opts.Shards),
+		keyspace: opts.Keyspace,
+		out:      bufio.NewWriterSize(os.Stdout, 1<<16),
+		ordered:  isPartitioned(opts),
+		nextLine: 1,
+		pending:  make(map[int64]*lineBuffer),
+		inFlight: make(chan struct{}, runtime.NumCPU()*4),
+	}
+	switch opts.Dedupe {
+	case "":
+	case "exact":
+		writer.seen = dedupe.NewExactSet()
+	case "bloom":
+		writer.seen = dedupe.NewBloomFilter(opts.DedupeSize, opts.DedupeRate)
+	default:
+		CheckError(errors.New("Invalid Dedupe Method"))
+	}
+	return writer
+}
+
+// isPartitioned checks if generation options skip, shard, limit or count
+// the output which needs the output in the same order on every run
+//
+// Args:
+//
+//	opts (models.GenerationOptions): Options to use for output
+//
+// Returns:
+//
+//	(bool): If the output stream is partitioned
+func isPartitioned(opts models.GenerationOptions) bool {
+	return opts.Skip > 0 || opts.Shards > 1 || opts.Limit > 0 || opts.Keyspace
+}
+
+// ParseShard parses a shard such as 2/4 into the shard and number of shards
+//
+// Args:
+//
+//	shard (string): Shard as i/n where i is from 1 to n (empty is 1/1)
+//
+// Returns:
+//
+//	(int): Shard from 1 to n
+//	(int): Number of shards
+func ParseShard(shard string) (int, int) {
+	if shard == "" {
+		return 1, 1
+	}
+
+	i, n, found := strings.Cut(shard, "/")
+	index, err := strconv.Atoi(i)
+	count, err2 := strconv.Atoi(n)
+	if !found || err != nil || err2 != nil || count < 1 || index < 1 || index > count {
+		CheckError(fmt.Errorf("Invalid Shard %s", shard))
+	}
+	return index, count
+}
+
+// Print prints a candidate unless it is skipped, in another shard, a
+// duplicate or the output limit has been reached
+//
+// Args:
+//
+//	candidate (string): Candidate to print
+//
+// Returns:
+//
+//	(bool): False if the limit has been reached and generation should stop
+func (w *candidateWriter) Print(candidate string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.emit(candidate)
+}
+
+// emit prints a candidate while holding the lock
+//
+// The index counts every candidate and the position counts the candidates
+// left after deduplication. Candidates are not counted once the limit is
+// reached so the count can be used to resume.
+func (w *candidateWriter) emit(candidate string) bool {
+	if !w.keyspace && w.Full() {
+		return false
+	}
+	w.index++
+	if w.seen != nil {
+		w.generated++
+		if !w.seen.Add(candidate) {
+			w.duplicates++
+			return true
+		}
+	}
+
+	position := w.position
+	w.position++
+	if w.keyspace || position < w.skip {
+		return true
+	}
+	if w.shards > 1 && (position-w.skip)%w.shards != w.shard-1 {
+		return true
+	}
+
+	atomic.AddInt64(&w.printed, 1)
+	w.out.WriteString(candidate)
+	w.out.WriteByte('\n')
+	return true
+}
+
+// lineBuffer holds the candidates of an input line that is made before every
+// earlier line is printed
+type lineBuffer struct {
+	mu         sync.Mutex
+	head       bool
+	finished   bool
+	candidates []string
+}
+
+// Line returns the functions used to print the candidates of an input line
+//
+// When the output is ordered the next line to be printed writes its
+// candidates straight through and only lines made ahead of it are held until
+// every earlier line is done. The number of lines in progress is bounded so
+// Line blocks until an earlier line is printed. Otherwise, and when only
+// counting the keyspace, candidates are printed as they are made. Line must
+// be called in line order starting at one and done must be called once for
+// every line.
+//
+// Args:
+//
+//	lineNumber (int64): Number of the input line
+//
+// Returns:
+//
+//	printCandidate (func(string) bool): Function that prints a candidate
+//	done (func()): Function called when the line has no more candidates
+func (w *candidateWriter) Line(lineNumber int64) (func(string) bool, func()) {
+	if !w.ordered || w.keyspace {
+		return w.Print, func() {}
+	}
+
+	w.inFlight <- struct{}{}
+	buf := &lineBuffer{}
+	w.mu.Lock()
+	w.pending[lineNumber] = buf
+	buf.head = lineNumber == w.nextLine
+	w.mu.Unlock()
+
+	printCandidate := func(candidate string) bool {
+		buf.mu.Lock()
+		if !buf.head {
+			buf.candidates = append(buf.candidates, candidate)
+			buf.mu.Unlock()
+			return !w.Full()
+		}
+		buf.mu.Unlock()
+		return w.Print(candidate)
+	}
+	done := func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		buf.mu.Lock()
+		buf.finished = true
+		buf.mu.Unlock()
+		w.advance()
+	}
+	return printCandidate, done
+}
+
+// advance prints the held candidates of every finished line in order and
+// lets the next unfinished line write straight through
+func (w *candidateWriter) advance() {
+	for {
+		buf, ok := w.pending[w.nextLine]
+		if !ok {
+			return
+		}
+
+		buf.mu.Lock()
+		for _, candidate := range buf.candidates {
+			if !w.emit(candidate) {
+				break
+			}
+		}
+		buf.candidates = nil
+		buf.head = true
+		finished := buf.finished
+		buf.mu.Unlock()
+		if !finished {
+			return
+		}
+
+		delete(w.pending, w.nextLine)
+		w.nextLine++
+		<-w.inFlight
+	}
+}
+
+// Flush writes any buffered candidates to stdout
+//
+// Returns:
+//
+//	None
+func (w *candidateWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	CheckError(w.out.Flush())
+}
+
+// Close prints the keyspace when counting and reports the duplicate rate to
+// stderr when deduplicating
+//
+// Returns:
+//
+//	None
+func (w *candidateWriter) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.keyspace {
+		fmt.Fprintln(w.out, w.position)
+	}
+	CheckError(w.out.Flush())
+	if w.seen == nil || w.quiet {
+		return
+	}
+
+	rate := 0.0
+	if w.generated > 0 {
+		rate = float64(w.duplicates) / float64(w.generated) * 100
+	}
+	fmt.Fprintf(os.Stderr, "[*] Removed %d duplicates of %d candidates (%.2f%%)\n", w.duplicates, w.generated, rate)
+}
+
+// Full checks if the output limit has been reached
+//
+// Returns:
+//
+//	(bool): If no more candidates will be printed
+func (w *candidateWriter) Full() bool {
+	return w.limit > 0 && atomic.LoadInt64(&w.printed) >= w.limit
+}
+
+// rangeTokenMap calls fn for each token in a sync.Map or for a random sample
+// of the tokens when sample is set
+//
+// Samples are taken with a bottom-k reservoir where each token is ranked by
+// a seeded hash so the same seed selects the same tokens whatever order the
+// map is ranged in and only the sample is held in memory.
+//
+// Args:
+//
+//	tokens (*sync.Map): Map of tokens to range over
+//	sample (int): Number of tokens to sample (0 uses all)
+//	rng (*rand.Rand): Random source to use
+//	fn (func(string) bool): Function to call, returning false stops iteration
+//
+// Returns:
+//
+//	None
+func rangeTokenMap(tokens *sync.Map, sample int, rng *rand.Rand, fn func(string) bool) {
+	if sample <= 0 {
+		tokens.Range(func(key, value interface{}) bool {
+			return fn(key.(string))
+		})
+		return
+	}
+
+	type ranked struct {
+		token string
+		rank  uint64
+	}
+	seed := rng.Uint64()
+	reservoir := make([]ranked, 0, sample)
+	largest := 0
+	tokens.Range(func(key, value interface{}) bool {
+		token := key.(string)
+		hash := fnv.New64a()
+		hash.Write([]byte(strconv.FormatUint(seed, 16)))
+		hash.Write([]byte(token))
+		candidate := ranked{token: token, rank: hash.Sum64()}
+
+		if len(reservoir) < sample {
+			reservoir = append(reservoir, candidate)
+			if candidate.rank > reservoir[largest].rank {
+				largest = len(reservoir) - 1
+			}
+			return true
+		}
+		if candidate.rank >= reservoir[largest].rank {
+			return true
+		}
+
+		reservoir[largest] = candidate
+		for i := range reservoir {
+			if reservoir[i].rank > reservoir[largest].rank {
+				largest = i
+			}
+		}
+		return true
+	})
+
+	// Sort the sample so the same seed prints the tokens in the same order
+	sort.Slice(reservoir, func(i, j int) bool { return reservoir[i].token < reservoir[j].token })
+	for _, r := range reservoir {
+		if !fn(r.token) {
+			return
+		}
+	}
+}
+
+// LoadCustomCharsets loads custom charsets from .hcchr files or definitions
+//
+// Args:
+//
+//	defs ([]string): File paths or charset definitions for ?1 to ?4 where
+//	empty values are not loaded
+//
+// Returns:
+//
+//	custom (map[byte]masks.Charset): Custom charsets keyed by '1' to '4'
+func LoadCustomCharsets(defs []string) map[byte]masks.Charset {
+	custom := make(map[byte]masks.Charset)
+	for i, def := range defs {
+		if def == "" {
+			continue
+		}
+
+		c, err := masks.LoadCharset(def)
+		if err != nil {
+			CheckError(fmt.Errorf("Invalid Custom Charset ?%d: %v", i+1, err))
+		}
+		custom[byte('1'+i)] = c
+	}
+	return custom
+}
+
+// LoadTokenFile reads a token file into weighted tokens sorted by weight
+//
+// Every line is a token with a weight of 1 unless the file is weighted when
+// lines can also be count:token or token\tweight. Duplicate tokens keep
+// their highest weight and tokens with equal weights keep their file order.
+//
+// Args:
+//
+//	infile (string): File path of token file to use
+//	doWeighted (bool): If lines should be parsed with utils.ParseWeightedToken
+//
+// Returns:
+//
+//	tokens ([]models.WeightedToken): Tokens sorted by weight descending
+func LoadTokenFile(infile string, doWeighted bool) []models.WeightedToken {
+	buf, err := os.Open(infile)
+	CheckError(err)
+
+	defer func() {
+		if err = buf.Close(); err != nil {
+			fmt.Println(err)
+			os.Exit(0)
+		}
+	}()
+
+	filescanner := bufio.NewScanner(buf)
+	tokens := []models.WeightedToken{}
+	seen := make(map[string]int)
+
+	for filescanner.Scan() {
+		token := models.WeightedToken{Token: filescanner.Text(), Weight: 1}
+		if doWeighted {
+			var ok bool
+			if token, ok = utils.ParseWeightedToken(filescanner.Text()); !ok {
+				continue
+			}
+		} else if token.Token == "" {
+			continue
+		}
+
+		if i, exists := seen[token.Token]; exists {
+			if token.Weight > tokens[i].Weight {
+				tokens[i].Weight = token.Weight
+			}
+			continue
+		}
+		seen[token.Token] = len(tokens)
+		tokens = append(tokens, token)
+	}
+
+	if err := filescanner.Err(); err != nil {
+		CheckError(err)
+	}
+
+	utils.SortWeightedTokens(tokens)
+	return tokens
 }
 
 // CheckIfArgExists checks an argument at a postion to see if it exists
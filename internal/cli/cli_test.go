@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jakewnuk/maskcat/pkg/models"
+)
+
+// captureOutput returns the lines fn prints to stdout
+func captureOutput(t *testing.T, fn func()) []string {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = writer
+	output := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(reader)
+		output <- data
+	}()
+
+	fn()
+	writer.Close()
+	os.Stdout = stdout
+	data := <-output
+	reader.Close()
+
+	if len(data) == 0 {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestLimitAndSkipPartition(t *testing.T) {
+	dir := t.TempDir()
+	tokens := filepath.Join(dir, "tokens.txt")
+	retain := filepath.Join(dir, "retain.txt")
+	if err := os.WriteFile(tokens, []byte("love\nhope\nsnow\nrain\nblue\nfire\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(retain, []byte("2024\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	input := strings.Builder{}
+	for i := 0; i < 60; i++ {
+		fmt.Fprintf(&input, "word%d2024\nWord%d2024!\n", i%17, i)
+	}
+
+	tests := []struct {
+		name string
+		run  func(stdIn *bufio.Scanner, opts models.GenerationOptions)
+	}{
+		{"sub", func(stdIn *bufio.Scanner, opts models.GenerationOptions) {
+			SubMasks(stdIn, tokens, false, false, false, 1, 0, 0, opts)
+		}},
+		{"mutate", func(stdIn *bufio.Scanner, opts models.GenerationOptions) {
+			MutateMasks(stdIn, "4", false, false, 1, 0, opts, false, "", false)
+		}},
+		{"splice", func(stdIn *bufio.Scanner, opts models.GenerationOptions) {
+			GenerateSpliceMutation(stdIn, retain, false, false, false, 1, 0, opts)
+		}},
+	}
+
+	for _, test := range tests {
+		generate := func(opts models.GenerationOptions) []string {
+			return captureOutput(t, func() {
+				test.run(bufio.NewScanner(strings.NewReader(input.String())), opts)
+			})
+		}
+
+		n := 50
+		stream := generate(models.GenerationOptions{Limit: 1 << 30})
+		if len(stream) < 2*n {
+			t.Fatalf("%s: made %d candidates; want at least %d", test.name, len(stream), 2*n)
+		}
+
+		first := generate(models.GenerationOptions{Limit: n})
+		second := generate(models.GenerationOptions{Skip: n, Limit: n})
+		if got := append(first, second...); !reflect.DeepEqual(got, stream[:2*n]) {
+			t.Errorf("%s: -limit %d then -skip %d -limit %d did not print the first %d candidates", test.name, n, n, n, 2*n)
+		}
+
+		again := generate(models.GenerationOptions{Limit: n})
+		if !reflect.DeepEqual(first, again) {
+			t.Errorf("%s: -limit %d printed different candidates on each run", test.name, n)
+		}
+	}
+}
+
+func TestDedupeShards(t *testing.T) {
+	dir := t.TempDir()
+	tokens := filepath.Join(dir, "tokens.txt")
+	if err := os.WriteFile(tokens, []byte("love\nhope\nsnow\nrain\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every line is given twice so every candidate is made twice
+	input := strings.Repeat("word2024\nlove2024\nabcd12\nrain99\n", 2)
+	generate := func(opts models.GenerationOptions) []string {
+		opts.Dedupe = "exact"
+		return captureOutput(t, func() {
+			SubMasks(bufio.NewScanner(strings.NewReader(input)), tokens, false, false, false, 1, 0, 0, opts)
+		})
+	}
+
+	unique := generate(models.GenerationOptions{Limit: 1 << 30})
+	keyspace := generate(models.GenerationOptions{Keyspace: true})
+	if want := fmt.Sprint(len(unique)); !reflect.DeepEqual(keyspace, []string{want}) {
+		t.Errorf("-keyspace with -dedupe printed %q; want %q", keyspace, want)
+	}
+
+	seen := make(map[string]bool)
+	for shard := 1; shard <= 2; shard++ {
+		for _, candidate := range generate(models.GenerationOptions{Shard: shard, Shards: 2}) {
+			if seen[candidate] {
+				t.Errorf("-dedupe printed %q in more than one shard", candidate)
+			}
+			seen[candidate] = true
+		}
+	}
+	if len(seen) != len(unique) {
+		t.Errorf("shards printed %d unique candidates; want %d", len(seen), len(unique))
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !unix
+
+package cli
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads a file into memory where memory mapping is not available
+//
+// Args:
+//
+//	file (*os.File): File to read
+//
+// Returns:
+//
+//	(data []byte): Contents of the file
+//	(func() error): Function that releases the contents
+//	(error): Error if the file could not be read
+func mapFile(file *os.File) ([]byte, func() error, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	data, err := io.ReadAll(file)
+	return data, func() error { return nil }, err
+}
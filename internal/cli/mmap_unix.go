@@ -0,0 +1,35 @@
+//go:build unix
+
+package cli
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps a file into memory read only
+//
+// Args:
+//
+//	file (*os.File): File to map
+//
+// Returns:
+//
+//	(data []byte): Contents of the file
+//	(func() error): Function that unmaps the file
+//	(error): Error if the file could not be mapped
+func mapFile(file *os.File) ([]byte, func() error, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Size() == 0 {
+		return nil, func() error { return nil }, nil
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
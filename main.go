@@ -9,8 +9,15 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/jakewnuk/maskcat/internal/cli"
+	"github.com/jakewnuk/maskcat/pkg/extsort"
+	"github.com/jakewnuk/maskcat/pkg/keyboard"
+	"github.com/jakewnuk/maskcat/pkg/masks"
+	"github.com/jakewnuk/maskcat/pkg/models"
+	"github.com/jakewnuk/maskcat/pkg/prince"
 )
 
 var version = "1.2.0"
@@ -22,6 +29,55 @@ func main() {
 	doDeHex := flagSet.Bool("d", false, "Process $HEX[...] text (warning: slows processes)\nExample: maskcat [MODE] -d")
 	doNumberOfReplacements := flagSet.Int("n", 1, "Max number of replacements to make per item (default: 1)\nExample: maskcat [MODE] -n 1")
 	doFuzzAmount := flagSet.Int("f", 0, "Adds extra fuzz to the replacement functions\nExample: maskcat [MODE] -f 1")
+	doLimit := flagSet.Int("limit", 0, "Max number of candidates to print (default: 0 prints all)\nExample: maskcat [MODE] -limit 1000000")
+	doMaxPerLine := flagSet.Int("max-per-line", 0, "Max number of candidates to print per input line (default: 0 prints all)\nExample: maskcat [MODE] -max-per-line 100")
+	doSample := flagSet.Int("sample", 0, "Randomly sample N tokens per input line (default: 0 uses all)\nExample: maskcat [MODE] -sample 500")
+	doSeed := flagSet.Int64("seed", 0, "Seed used for random sampling\nExample: maskcat [MODE] -sample 500 -seed 42")
+	doDedupe := flagSet.String("dedupe", "", "Remove duplicate candidates using \"exact\" or memory bounded \"bloom\" sets\nExample: maskcat [MODE] -dedupe exact")
+	doDedupeSize := flagSet.Int("dedupe-size", 10000000, "Expected number of unique candidates for -dedupe bloom\nExample: maskcat [MODE] -dedupe bloom -dedupe-size 100000000")
+	doDedupeRate := flagSet.Float64("dedupe-rate", 0.001, "False positive rate for -dedupe bloom\nExample: maskcat [MODE] -dedupe bloom -dedupe-rate 0.0001")
+	doTwoPass := flagSet.Bool("two-pass", false, "Harvest all tokens before mutating for complete and reproducible output\nExample: maskcat mutate [MIN-TOKEN-SIZE] -two-pass")
+	doTokenFile := flagSet.String("token-file", "", "Harvest mutation tokens from a file instead of stdin\nExample: maskcat mutate [MIN-TOKEN-SIZE] -token-file corpus.txt")
+	doSpill := flagSet.Bool("spill", false, "Store harvested tokens on disk instead of in memory\nExample: maskcat mutate [MIN-TOKEN-SIZE] -two-pass -spill")
+	doWeighted := flagSet.Bool("weighted", false, "Read token files as weighted count:token or token<TAB>weight lines\nExample: maskcat sub [TOKENS-FILE] -weighted")
+	doMaxTokens := flagSet.Int("max-tokens", 0, "Max number of tokens to use per token mask (default: 0 uses all)\nExample: maskcat sub [TOKENS-FILE] -max-tokens 100")
+	doInvert := flagSet.Bool("invert", false, "Print input that does not match instead\nExample: maskcat match [MASK-FILE] -invert")
+	doAnnotate := flagSet.Bool("annotate", false, "Print matches as plaintext:mask:line-number-of-mask\nExample: maskcat match [MASK-FILE] -annotate")
+	doFormat := flagSet.String("format", "table", "Output format of reports (table, csv, json)\nExample: maskcat coverage [MASK-FILE] -format csv")
+	doRegex := flagSet.Bool("regex", false, "Treat the mask file as regular expressions\nExample: maskcat match [REGEX-FILE] -regex")
+	doReverse := flagSet.Bool("reverse", false, "Convert regular expressions into masks instead\nExample: maskcat regex -reverse")
+	doJohn := flagSet.Bool("john", false, "Read or print masks in John the Ripper syntax\nExample: maskcat mask -john")
+	doHex := flagSet.Bool("hex", false, "Detect hex runs and print them as ?h or ?H\nExample: maskcat mask -hex")
+	doHexLength := flagSet.Int("hex-len", 6, "Minimum length of hex runs to detect\nExample: maskcat mask -hex -hex-len 8")
+	doCollapse := flagSet.Bool("collapse", false, "Collapse printable mask characters to ?a\nExample: maskcat mask -collapse")
+	doWalk := flagSet.Bool("walk", false, "Detect keyboard walks in tokens, retain and mask modes\nExample: maskcat tokens -walk")
+	doWalkLength := flagSet.Int("walk-len", keyboard.DefaultMinLength, "Minimum length of keyboard walks\nExample: maskcat tokens -walk -walk-len 5")
+	doSemantic := flagSet.Bool("semantic", false, "Recognize years, dates, months and seasons in tokens and mask modes\nExample: maskcat mask -semantic")
+	doExpand := flagSet.Bool("expand", false, "Expand years, dates, months and seasons into realistic masks\nExample: maskcat mask -expand")
+	doExpandMax := flagSet.Int("expand-max", 1000, "Max number of masks to expand each string into (0 expands all)\nExample: maskcat mask -expand -expand-max 100")
+	doCheckpoint := flagSet.String("checkpoint", "", "File to save progress to and resume from\nExample: maskcat generate [GRAMMAR-FILE] -checkpoint generate.restore")
+	doModel := flagSet.String("model", "mask", "Model used to score candidates (mask, markov)\nExample: maskcat score [TRAINING-FILE] -model markov")
+	doSort := flagSet.Bool("sort", false, "Sort output in byte order (score mode sorts from the most to least likely)\nExample: maskcat [MODE] -sort")
+	doUnique := flagSet.Bool("unique", false, "Sort output and remove duplicate lines\nExample: maskcat [MODE] -unique")
+	doMemory := flagSet.Int("mem", extsort.DefaultMemory>>20, "Memory in MiB to use when sorting before writing temporary files\nExample: maskcat [MODE] -sort -mem 1024")
+	doTmpDir := flagSet.String("tmp-dir", "", "Directory for temporary files when sorting (default: system temporary directory)\nExample: maskcat [MODE] -sort -tmp-dir /mnt/scratch")
+	doSeparator := flagSet.String("sep", "", "Separator placed between tokens of adjacent template slots\nExample: maskcat combine [TEMPLATE] [TOKEN-FILES] -sep _")
+	doMaxElements := flagSet.Int("elem-max", prince.DefaultMaxElements, "Maximum number of elements in a chain\nExample: maskcat prince -elem-max 4")
+	doSkip := flagSet.Int("skip", 0, "Number of candidates to skip before printing\nExample: maskcat [MODE] -skip 1000000")
+	doShard := flagSet.String("shard", "", "Print only shard i of n of the candidates\nExample: maskcat [MODE] -shard 1/4")
+	doKeyspace := flagSet.Bool("keyspace", false, "Print the number of candidates instead of the candidates\nExample: maskcat [MODE] -keyspace")
+	doLayout := flagSet.String("layout", "qwerty", "Keyboard layout for keyboard walks (qwerty, qwertz, azerty)\nExample: maskcat mask -walk -layout qwertz")
+	doCharset1 := flagSet.String("1", "", "Custom charset ?1 as a .hcchr file or definition\nExample: maskcat mask -1 german.hcchr")
+	doCharset2 := flagSet.String("2", "", "Custom charset ?2 as a .hcchr file or definition\nExample: maskcat match [MASK-FILE] -2 ?l?d")
+	doCharset3 := flagSet.String("3", "", "Custom charset ?3 as a .hcchr file or definition\nExample: maskcat mask -3 french.hcchr")
+	doCharset4 := flagSet.String("4", "", "Custom charset ?4 as a .hcchr file or definition\nExample: maskcat mask -4 ?u?d")
+	doMinLength := flagSet.Int("min-len", 0, "Minimum length of tokens to print\nExample: maskcat tokens -min-len 4")
+	doMaxLength := flagSet.Int("max-len", 0, "Maximum length of tokens to print (default: 0 allows all)\nExample: maskcat tokens -max-len 8")
+	doCount := flagSet.Bool("count", false, "Count token frequencies and print them as count:token\nExample: maskcat tokens -count")
+	doTopN := flagSet.Int("top", 0, "Only print the N most frequent tokens when counting (default: 0 prints all)\nExample: maskcat tokens -count -top 1000")
+	doMinCount := flagSet.Int("min-count", 0, "Only print tokens seen at least N times when counting\nExample: maskcat tokens -count -min-count 5")
+	doApprox := flagSet.Int("approx", 0, "Approximate counts by only tracking N tokens in memory (default: 0 counts exactly)\nExample: maskcat tokens -count -approx 100000")
+	doTokenClass := flagSet.String("class", "alpha", "Comma separated token classes to print (alpha, digit, special, mixed, multibyte)\nExample: maskcat tokens -class digit,special")
 	flagSet.Usage = func() {
 		fmt.Fprintf(flagSet.Output(), "Options for maskcat (version %s):\n\n", version)
 		flagSet.PrintDefaults()
@@ -36,48 +92,187 @@ func main() {
 	}
 
 	stdIn := bufio.NewScanner(os.Stdin)
+	customCharsets := func() map[byte]masks.Charset {
+		return cli.LoadCustomCharsets([]string{*doCharset1, *doCharset2, *doCharset3, *doCharset4})
+	}
+	genOpts := func() models.GenerationOptions {
+		shard, shards := cli.ParseShard(*doShard)
+		return models.GenerationOptions{
+			Limit:      *doLimit,
+			MaxPerLine: *doMaxPerLine,
+			Sample:     *doSample,
+			Seed:       *doSeed,
+			Dedupe:     *doDedupe,
+			DedupeSize: *doDedupeSize,
+			DedupeRate: *doDedupeRate,
+			Skip:       *doSkip,
+			Shard:      shard,
+			Shards:     shards,
+			Keyspace:   *doKeyspace,
+		}
+	}
+
+	// Output is sorted for every mode except modes that sort it themselves
+	// and reports that are not a list of lines
+	finish := func() {}
+	parseFlags := func(args []string) {
+		flagSet.Parse(args)
+		if !*doSort && !*doUnique {
+			return
+		}
+		switch os.Args[1] {
+		case "score", "sort", "rank":
+		case "coverage":
+			cli.CheckError(fmt.Errorf("Invalid Sort Option: %s output is a report", os.Args[1]))
+		default:
+			finish = cli.SortOutput(*doUnique, *doMemory<<20, *doTmpDir)
+		}
+	}
 
 	switch os.Args[1] {
 	case "mask":
-		flagSet.Parse(os.Args[2:])
-		cli.GenerateMasks(stdIn, *doMultiByte, *doDeHex, *doVerbose)
+		parseFlags(os.Args[2:])
+		hexLength := 0
+		if *doHex {
+			hexLength = *doHexLength
+		}
+		cli.GenerateMasks(stdIn, *doMultiByte, *doDeHex, *doVerbose, *doJohn, customCharsets(), hexLength, *doCollapse, cli.LoadLayout(*doLayout, *doWalk), *doWalkLength, *doInvert, *doSemantic, *doExpand, *doExpandMax, genOpts())
 	case "match":
 		cli.CheckIfArgExists(2, os.Args)
-		flagSet.Parse(os.Args[3:])
-		cli.MatchMasks(stdIn, os.Args[2], *doMultiByte, *doDeHex)
+		parseFlags(os.Args[3:])
+		cli.MatchMasks(stdIn, os.Args[2], *doMultiByte, *doDeHex, *doInvert, *doAnnotate, *doRegex, *doJohn, customCharsets())
+	case "coverage":
+		cli.CheckIfArgExists(2, os.Args)
+		parseFlags(os.Args[3:])
+		cli.CalculateCoverage(stdIn, os.Args[2], *doMultiByte, *doDeHex, *doFormat, *doJohn, customCharsets())
+	case "regex":
+		parseFlags(os.Args[2:])
+		cli.ConvertRegex(stdIn, *doReverse)
 	case "sub":
 		cli.CheckIfArgExists(2, os.Args)
-		flagSet.Parse(os.Args[3:])
-		cli.SubMasks(stdIn, os.Args[2], *doMultiByte, *doDeHex, *doNumberOfReplacements, *doFuzzAmount)
+		parseFlags(os.Args[3:])
+		cli.SubMasks(stdIn, os.Args[2], *doWeighted, *doMultiByte, *doDeHex, *doNumberOfReplacements, *doFuzzAmount, *doMaxTokens, genOpts())
 	case "mutate":
 		cli.CheckIfArgExists(2, os.Args)
-		flagSet.Parse(os.Args[3:])
-		cli.MutateMasks(stdIn, os.Args[2], *doMultiByte, *doDeHex, *doNumberOfReplacements, *doFuzzAmount)
+		parseFlags(os.Args[3:])
+		cli.MutateMasks(stdIn, os.Args[2], *doMultiByte, *doDeHex, *doNumberOfReplacements, *doFuzzAmount, genOpts(), *doTwoPass, *doTokenFile, *doSpill)
 	case "tokens":
-		cli.CheckIfArgExists(2, os.Args)
-		flagSet.Parse(os.Args[3:])
-		cli.GenerateTokens(stdIn, os.Args[2], *doDeHex)
+		// The token length used to be a positional argument so a leading
+		// number is still read as an exact length where 98 and over allow all
+		minLength, maxLength := doMinLength, doMaxLength
+		if len(os.Args) > 2 && models.IsStringInt(os.Args[2]) {
+			parseFlags(os.Args[3:])
+			if length, err := strconv.Atoi(os.Args[2]); err == nil && length < 98 {
+				if !isFlagSet(flagSet, "min-len") {
+					minLength = &length
+				}
+				if !isFlagSet(flagSet, "max-len") {
+					maxLength = &length
+				}
+			}
+		} else {
+			parseFlags(os.Args[2:])
+		}
+		if flagSet.NArg() > 0 {
+			cli.CheckError(fmt.Errorf("Invalid Argument: %q is not an option", flagSet.Arg(0)))
+		}
+		// Keyboard walks and semantic tokens print every class unless classes are selected
+		tokenClass := *doTokenClass
+		if (*doWalk || *doSemantic) && !isFlagSet(flagSet, "class") {
+			tokenClass = "alpha,digit,special,mixed,multibyte"
+		}
+		layout := cli.LoadLayout(*doLayout, *doWalk)
+		if *doCount {
+			cli.CountTokens(stdIn, *minLength, *maxLength, tokenClass, *doTopN, *doMinCount, *doApprox, *doDeHex, layout, *doWalkLength, *doSemantic)
+		} else {
+			cli.GenerateTokens(stdIn, *minLength, *maxLength, tokenClass, *doDeHex, layout, *doWalkLength, *doSemantic)
+		}
 	case "partial":
 		cli.CheckIfArgExists(2, os.Args)
-		flagSet.Parse(os.Args[3:])
-		cli.GeneratePartialMasks(stdIn, os.Args[2], *doDeHex)
+		parseFlags(os.Args[3:])
+		cli.GeneratePartialMasks(stdIn, os.Args[2], *doDeHex, *doJohn, customCharsets(), *doHexLength)
 	case "remove":
 		cli.CheckIfArgExists(2, os.Args)
-		flagSet.Parse(os.Args[3:])
-		cli.GeneratePartialRemoveMasks(stdIn, os.Args[2], *doDeHex)
+		parseFlags(os.Args[3:])
+		cli.GeneratePartialRemoveMasks(stdIn, os.Args[2], *doDeHex, *doHexLength)
 	case "retain":
-		cli.CheckIfArgExists(2, os.Args)
-		flagSet.Parse(os.Args[3:])
-		cli.GenerateTokenRetainMasks(stdIn, os.Args[2], *doMultiByte, *doDeHex, *doNumberOfReplacements)
+		// The tokens file is optional when keyboard walks are retained
+		infile := ""
+		if len(os.Args) > 2 && !strings.HasPrefix(os.Args[2], "-") {
+			infile = os.Args[2]
+			parseFlags(os.Args[3:])
+		} else {
+			parseFlags(os.Args[2:])
+			if !*doWalk {
+				cli.CheckError(fmt.Errorf("Not enough arguments provided"))
+			}
+		}
+		cli.GenerateTokenRetainMasks(stdIn, infile, *doWeighted, *doMultiByte, *doDeHex, *doNumberOfReplacements, cli.LoadLayout(*doLayout, *doWalk), *doWalkLength)
 	case "splice":
 		cli.CheckIfArgExists(2, os.Args)
-		flagSet.Parse(os.Args[3:])
-		cli.GenerateSpliceMutation(stdIn, os.Args[2], *doMultiByte, *doDeHex, *doNumberOfReplacements, *doFuzzAmount)
+		parseFlags(os.Args[3:])
+		cli.GenerateSpliceMutation(stdIn, os.Args[2], *doWeighted, *doMultiByte, *doDeHex, *doNumberOfReplacements, *doFuzzAmount, genOpts())
+	case "combine":
+		cli.CheckIfArgExists(3, os.Args)
+		// Every argument after the template up to the first flag is a token file
+		infiles := []string{}
+		for _, arg := range os.Args[3:] {
+			if strings.HasPrefix(arg, "-") {
+				break
+			}
+			infiles = append(infiles, arg)
+		}
+		parseFlags(os.Args[3+len(infiles):])
+		cli.CombineTokens(os.Args[2], infiles, *doWeighted, *doSeparator, *doMinLength, *doMaxLength, genOpts())
+	case "prince":
+		// The tokens file is optional as tokens are harvested from stdin
+		infile := ""
+		if len(os.Args) > 2 && !strings.HasPrefix(os.Args[2], "-") {
+			infile = os.Args[2]
+			parseFlags(os.Args[3:])
+		} else {
+			parseFlags(os.Args[2:])
+		}
+		cli.GeneratePrince(stdIn, infile, *doWeighted, *doMultiByte, *doDeHex, *doMinLength, *doMaxLength, *doMaxElements, genOpts())
+	case "structure":
+		cli.CheckIfArgExists(2, os.Args)
+		parseFlags(os.Args[3:])
+		cli.GenerateStructures(stdIn, os.Args[2], *doMultiByte, *doDeHex, *doCount)
+	case "markov":
+		cli.CheckIfArgExists(2, os.Args)
+		parseFlags(os.Args[3:])
+		cli.GenerateMarkov(stdIn, os.Args[2], *doMultiByte, *doDeHex)
+	case "score":
+		cli.CheckIfArgExists(2, os.Args)
+		parseFlags(os.Args[3:])
+		cli.ScoreCandidates(stdIn, os.Args[2], *doModel, *doMultiByte, *doDeHex, *doSort, *doUnique, *doMemory<<20, *doTmpDir)
+	case "generate":
+		cli.CheckIfArgExists(2, os.Args)
+		parseFlags(os.Args[3:])
+		cli.GenerateCandidates(os.Args[2], *doMultiByte, *doDeHex, *doCheckpoint, genOpts())
 	case "filter":
 		cli.CheckIfArgExists(2, os.Args)
-		flagSet.Parse(os.Args[3:])
+		parseFlags(os.Args[3:])
 		cli.CalculateEntropy(stdIn, os.Args[2], *doMultiByte, *doVerbose)
+	case "sort":
+		parseFlags(os.Args[2:])
+		cli.SortLines(os.Stdin, *doUnique, *doMemory<<20, *doTmpDir)
+	case "rank":
+		parseFlags(os.Args[2:])
+		cli.RankLines(os.Stdin, *doVerbose, *doMinCount, *doTopN, *doMemory<<20, *doTmpDir)
 	}
+	finish()
+}
+
+// isFlagSet tests if a flag was given on the command line
+func isFlagSet(flagSet *flag.FlagSet, name string) bool {
+	found := false
+	flagSet.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
 }
 
 // printUsage prints usage information for the application
@@ -87,12 +282,16 @@ func printUsage() {
 	fmt.Println("\t\tExample: stdin | maskcat mask [OPTIONS]")
 	fmt.Println("\n  match\t\tMatches text to masks")
 	fmt.Println("\t\tExample: stdin | maskcat match [MASK-FILE] [OPTIONS]")
+	fmt.Println("\n  coverage\tReports how many inputs each mask in a file covers")
+	fmt.Println("\t\tExample: stdin | maskcat coverage [MASK-FILE] [OPTIONS]")
+	fmt.Println("\n  regex\t\tConverts masks into regular expressions or back")
+	fmt.Println("\t\tExample: stdin | maskcat regex [OPTIONS]")
 	fmt.Println("\n  sub\t\tReplaces text with text from a file with masks")
 	fmt.Println("\t\tExample: stdin | maskcat sub [TOKENS-FILE] [OPTIONS]")
 	fmt.Println("\n  mutate\tMutates text by using chunking and token swapping")
 	fmt.Println("\t\tExample: stdin | maskcat mutate [MIN-TOKEN-SIZE] [OPTIONS]")
-	fmt.Println("\n  tokens\tSplits text into tokens and only print certain lengths (values over 99 allow all)")
-	fmt.Println("\t\tExample: stdin | maskcat tokens [TOKEN-LEN] [OPTIONS]")
+	fmt.Println("\n  tokens\tSplits text into tokens and only print certain lengths and classes")
+	fmt.Println("\t\tExample: stdin | maskcat tokens [OPTIONS]")
 	fmt.Println("\n  partial\tPartially replaces characters with mask characters")
 	fmt.Println("\t\tExample: stdin | maskcat partial [MASK-CHARS] [OPTIONS]")
 	fmt.Println("\n  remove\tRemoves characters that match given mask characters")
@@ -101,6 +300,22 @@ func printUsage() {
 	fmt.Println("\t\tExample: stdin | maskcat retain [TOKENS-FILE] [OPTIONS]")
 	fmt.Println("\n  splice\tMutates text by using retain masks and token swapping")
 	fmt.Println("\t\tExample: stdin | maskcat splice [TOKENS-FILE] [OPTIONS]")
+	fmt.Println("\n  combine\tJoins tokens from token files into the slots of a template")
+	fmt.Println("\t\tExample: maskcat combine [TEMPLATE] [TOKENS-FILE] [TOKENS-FILE] [OPTIONS]")
+	fmt.Println("\n  prince\tChains tokens into candidates in order of probability")
+	fmt.Println("\t\tExample: stdin | maskcat prince [TOKENS-FILE] [OPTIONS]")
 	fmt.Println("\n  filter\tOnly prints masks below a maximum entropy threshold")
 	fmt.Println("\t\tExample: stdin | maskcat filter [ENTROPY-MAX] [OPTIONS]")
+	fmt.Println("\n  structure\tPrints base structures and writes a learned grammar")
+	fmt.Println("\t\tExample: stdin | maskcat structure [GRAMMAR-FILE] [OPTIONS]")
+	fmt.Println("\n  markov\t\tWrites per-position Markov statistics to an .hcstat2 file")
+	fmt.Println("\t\tExample: stdin | maskcat markov [HCSTAT2-FILE] [OPTIONS]")
+	fmt.Println("\n  score\t\tPrints candidates with their log probability under a model")
+	fmt.Println("\t\tExample: stdin | maskcat score [TRAINING-FILE] [OPTIONS]")
+	fmt.Println("\n  generate\tGenerates candidates from a grammar in order of probability")
+	fmt.Println("\t\tExample: maskcat generate [GRAMMAR-FILE] [OPTIONS]")
+	fmt.Println("\n  sort\t\tSorts stdin in byte order using temporary files for large input")
+	fmt.Println("\t\tExample: stdin | maskcat sort [OPTIONS]")
+	fmt.Println("\n  rank\t\tPrints distinct lines of stdin from the most to least frequent")
+	fmt.Println("\t\tExample: stdin | maskcat rank [OPTIONS]")
 }
@@ -0,0 +1,184 @@
+// Package counter contains exact and approximate frequency counters
+//
+// The package structure is broken into two components:
+//
+// counter.go which contains the primary logic
+// counter_test.go which contains unit tests
+package counter
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/jakewnuk/maskcat/pkg/models"
+)
+
+// Counter is implemented by all frequency counters
+type Counter interface {
+	Add(token string)
+	Items() []models.TokenCount
+}
+
+// ExactCounter counts every token in memory
+type ExactCounter struct {
+	counts map[string]int
+}
+
+// NewExactCounter creates a new exact counter
+//
+// Returns:
+//
+//	(*ExactCounter): Empty counter
+func NewExactCounter() *ExactCounter {
+	return &ExactCounter{counts: make(map[string]int)}
+}
+
+// Add increments the count of a token
+//
+// Args:
+//
+//	token (string): Token to count
+//
+// Returns:
+//
+//	None
+func (c *ExactCounter) Add(token string) {
+	c.counts[token]++
+}
+
+// Items returns the counted tokens sorted by count descending
+//
+// Returns:
+//
+//	([]models.TokenCount): Counted tokens
+func (c *ExactCounter) Items() []models.TokenCount {
+	items := make([]models.TokenCount, 0, len(c.counts))
+	for token, count := range c.counts {
+		items = append(items, models.TokenCount{Token: token, Count: count})
+	}
+	SortTokenCounts(items)
+	return items
+}
+
+// SpaceSaving approximates the most frequent tokens in bounded memory
+//
+// The counter implements the Space-Saving algorithm which only tracks a fixed
+// number of tokens. When a new token is seen and the counter is full the
+// least frequent token is evicted and the new token inherits its count. The
+// reported counts are an upper bound and are exact for frequent tokens.
+type SpaceSaving struct {
+	capacity int
+	index    map[string]*entry
+	entries  entryHeap
+}
+
+type entry struct {
+	token string
+	count int
+	pos   int
+}
+
+type entryHeap []*entry
+
+func (h entryHeap) Len() int { return len(h) }
+func (h entryHeap) Less(i, j int) bool {
+	return h[i].count < h[j].count
+}
+func (h entryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos = i
+	h[j].pos = j
+}
+func (h *entryHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.pos = len(*h)
+	*h = append(*h, e)
+}
+func (h *entryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// NewSpaceSaving creates a new approximate counter tracking at most capacity
+// tokens
+//
+// Args:
+//
+//	capacity (int): Maximum number of tokens to track
+//
+// Returns:
+//
+//	(*SpaceSaving): Empty counter
+func NewSpaceSaving(capacity int) *SpaceSaving {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &SpaceSaving{capacity: capacity, index: make(map[string]*entry)}
+}
+
+// Add increments the count of a token
+//
+// Args:
+//
+//	token (string): Token to count
+//
+// Returns:
+//
+//	None
+func (c *SpaceSaving) Add(token string) {
+	if e, ok := c.index[token]; ok {
+		e.count++
+		heap.Fix(&c.entries, e.pos)
+		return
+	}
+
+	if len(c.entries) < c.capacity {
+		e := &entry{token: token, count: 1}
+		heap.Push(&c.entries, e)
+		c.index[token] = e
+		return
+	}
+
+	// Evict the least frequent token and inherit its count
+	e := c.entries[0]
+	delete(c.index, e.token)
+	e.token = token
+	e.count++
+	c.index[token] = e
+	heap.Fix(&c.entries, e.pos)
+}
+
+// Items returns the tracked tokens sorted by count descending
+//
+// Returns:
+//
+//	([]models.TokenCount): Counted tokens
+func (c *SpaceSaving) Items() []models.TokenCount {
+	items := make([]models.TokenCount, 0, len(c.entries))
+	for _, e := range c.entries {
+		items = append(items, models.TokenCount{Token: e.token, Count: e.count})
+	}
+	SortTokenCounts(items)
+	return items
+}
+
+// SortTokenCounts sorts counted tokens by count descending and then by token
+// so output is deterministic
+//
+// Args:
+//
+//	items ([]models.TokenCount): Counted tokens to sort in place
+//
+// Returns:
+//
+//	None
+func SortTokenCounts(items []models.TokenCount) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Token < items[j].Token
+	})
+}
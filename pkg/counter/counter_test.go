@@ -0,0 +1,48 @@
+package counter
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jakewnuk/maskcat/pkg/models"
+)
+
+func TestExactCounter(t *testing.T) {
+	c := NewExactCounter()
+	for _, token := range []string{"2024", "love", "2024", "!", "love", "2024"} {
+		c.Add(token)
+	}
+
+	want := []models.TokenCount{{Token: "2024", Count: 3}, {Token: "love", Count: 2}, {Token: "!", Count: 1}}
+	got := c.Items()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExactCounter.Items() = %v; want %v", got, want)
+	}
+}
+
+func TestSpaceSaving(t *testing.T) {
+	c := NewSpaceSaving(2)
+	for _, token := range []string{"a", "a", "a", "b", "b", "c", "a"} {
+		c.Add(token)
+	}
+
+	got := c.Items()
+	if len(got) != 2 {
+		t.Fatalf("SpaceSaving.Items() returned %d items; want 2", len(got))
+	}
+	if got[0].Token != "a" || got[0].Count != 4 {
+		t.Errorf("SpaceSaving.Items()[0] = %v; want {a 4}", got[0])
+	}
+	if got[1].Token != "c" || got[1].Count != 3 {
+		t.Errorf("SpaceSaving.Items()[1] = %v; want {c 3}", got[1])
+	}
+}
+
+func TestSortTokenCounts(t *testing.T) {
+	items := []models.TokenCount{{Token: "b", Count: 1}, {Token: "a", Count: 1}, {Token: "c", Count: 5}}
+	want := []models.TokenCount{{Token: "c", Count: 5}, {Token: "a", Count: 1}, {Token: "b", Count: 1}}
+	SortTokenCounts(items)
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("SortTokenCounts() = %v; want %v", items, want)
+	}
+}
@@ -0,0 +1,137 @@
+// Package dedupe contains sets used to remove duplicate candidates
+//
+// The package structure is broken into two components:
+//
+// dedupe.go which contains the primary logic
+// dedupe_test.go which contains unit tests
+package dedupe
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// Set is implemented by all deduplication sets
+//
+// Sets are safe for concurrent use.
+type Set interface {
+	// Add adds an item and returns true if it was not seen before
+	Add(item string) bool
+}
+
+// ExactSet remembers every item in memory and never reports false duplicates
+type ExactSet struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+// NewExactSet creates a new exact set
+//
+// Returns:
+//
+//	(*ExactSet): Empty set
+func NewExactSet() *ExactSet {
+	return &ExactSet{seen: make(map[string]struct{})}
+}
+
+// Add adds an item to the set
+//
+// Args:
+//
+//	item (string): Item to add
+//
+// Returns:
+//
+//	(bool): True if the item was not in the set
+func (s *ExactSet) Add(item string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.seen[item]; ok {
+		return false
+	}
+	s.seen[item] = struct{}{}
+	return true
+}
+
+// BloomFilter remembers items in a fixed amount of memory
+//
+// A Bloom filter can report an item as seen when it was not (a false
+// positive) but never the other way around. When used for deduplication this
+// means a small fraction of unique items can be dropped.
+type BloomFilter struct {
+	mu     sync.Mutex
+	bits   []uint64
+	size   uint64
+	hashes uint64
+}
+
+// NewBloomFilter creates a Bloom filter sized for an expected number of items
+// and false positive rate
+//
+// Args:
+//
+//	items (int): Expected number of unique items
+//	falsePositive (float64): Acceptable false positive rate between 0 and 1
+//
+// Returns:
+//
+//	(*BloomFilter): Empty filter
+func NewBloomFilter(items int, falsePositive float64) *BloomFilter {
+	if items < 1 {
+		items = 1
+	}
+	if falsePositive <= 0 || falsePositive >= 1 {
+		falsePositive = 0.001
+	}
+
+	// m = -n*ln(p) / ln(2)^2 and k = m/n * ln(2)
+	size := uint64(math.Ceil(-float64(items) * math.Log(falsePositive) / (math.Ln2 * math.Ln2)))
+	if size < 64 {
+		size = 64
+	}
+	hashes := uint64(math.Round(float64(size) / float64(items) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+
+	return &BloomFilter{
+		bits:   make([]uint64, (size+63)/64),
+		size:   size,
+		hashes: hashes,
+	}
+}
+
+// Add adds an item to the filter
+//
+// Args:
+//
+//	item (string): Item to add
+//
+// Returns:
+//
+//	(bool): True if the item was not in the filter
+func (b *BloomFilter) Add(item string) bool {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	sum := h.Sum64()
+
+	// Double hashing derives every index from two halves of one hash
+	h1 := sum & 0xffffffff
+	h2 := sum>>32 | 1
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	added := false
+	for i := uint64(0); i < b.hashes; i++ {
+		bit := (h1 + i*h2) % b.size
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if b.bits[word]&mask == 0 {
+			b.bits[word] |= mask
+			added = true
+		}
+	}
+	return added
+}
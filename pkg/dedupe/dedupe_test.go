@@ -0,0 +1,47 @@
+package dedupe
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestExactSet(t *testing.T) {
+	s := NewExactSet()
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"love2024", true},
+		{"love2023", true},
+		{"love2024", false},
+		{"", true},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		result := s.Add(test.input)
+		if result != test.expected {
+			t.Errorf("ExactSet.Add(%q) = %v; want %v", test.input, result, test.expected)
+		}
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	b := NewBloomFilter(10000, 0.001)
+	falsePositives := 0
+
+	for i := 0; i < 10000; i++ {
+		if !b.Add(fmt.Sprintf("candidate%d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 100 {
+		t.Errorf("BloomFilter reported %d false duplicates out of 10000; want at most 100", falsePositives)
+	}
+
+	for i := 0; i < 10000; i++ {
+		if b.Add(fmt.Sprintf("candidate%d", i)) {
+			t.Fatalf("BloomFilter.Add(%q) = true for a repeated item; want false", fmt.Sprintf("candidate%d", i))
+		}
+	}
+}
@@ -0,0 +1,303 @@
+// Package extsort contains an external merge sort for lines that do not fit
+// in memory
+//
+// The package structure is broken into two components:
+//
+// extsort.go which contains the primary logic
+// extsort_test.go which contains unit tests
+package extsort
+
+import (
+	"bufio"
+	"container/heap"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultMemory is the default number of bytes of lines held in memory
+// before they are written to a temporary file
+const DefaultMemory = 256 << 20
+
+// maxRuns is the most temporary files merged at once
+const maxRuns = 128
+
+// lineOverhead estimates the memory used by each line beyond its bytes
+const lineOverhead = 16
+
+// Sorter sorts lines in byte order
+//
+// Lines are held in memory until they use more than the memory budget and
+// are then sorted and written to a temporary file called a run. The runs are
+// merged when the sorted lines are read. Lines must not contain newlines.
+type Sorter struct {
+	memory int
+	dir    string
+	unique bool
+	lines  []string
+	size   int
+	runs   []string
+}
+
+// NewSorter creates a sorter
+//
+// Args:
+//
+//	memory (int): Bytes of lines to hold in memory (0 uses DefaultMemory)
+//	dir (string): Directory for temporary files (empty uses os.TempDir)
+//	unique (bool): If duplicate lines should be removed
+//
+// Returns:
+//
+//	(*Sorter): Sorter
+func NewSorter(memory int, dir string, unique bool) *Sorter {
+	if memory <= 0 {
+		memory = DefaultMemory
+	}
+	return &Sorter{memory: memory, dir: dir, unique: unique}
+}
+
+// Add adds a line to the sorter
+//
+// Args:
+//
+//	line (string): Line without a newline
+//
+// Returns:
+//
+//	(error): Error if a run could not be written
+func (s *Sorter) Add(line string) error {
+	s.lines = append(s.lines, line)
+	s.size += len(line) + lineOverhead
+	if s.size >= s.memory {
+		return s.spill()
+	}
+	return nil
+}
+
+// AddLines adds every line of a reader to the sorter
+//
+// Lines are only split on newlines so carriage returns and other bytes are
+// kept as they are. A last line without a newline is added as well.
+//
+// Args:
+//
+//	r (io.Reader): Input reader
+//
+// Returns:
+//
+//	(error): Error if any
+func (s *Sorter) AddLines(r io.Reader) error {
+	reader := bufio.NewReaderSize(r, 1<<16)
+	for {
+		line, ok, err := readLine(reader)
+		if err != nil || !ok {
+			return err
+		}
+		if err := s.Add(line); err != nil {
+			return err
+		}
+	}
+}
+
+// spill sorts the lines in memory and writes them to a new run
+func (s *Sorter) spill() error {
+	s.sortLines()
+	err := s.writeRun(func(w *bufio.Writer) error {
+		for _, line := range s.lines {
+			w.WriteString(line)
+			if err := w.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	s.lines = s.lines[:0]
+	s.size = 0
+	return err
+}
+
+// sortLines sorts the lines in memory and removes duplicates if unique
+func (s *Sorter) sortLines() {
+	sort.Strings(s.lines)
+	if !s.unique {
+		return
+	}
+
+	kept := s.lines[:0]
+	for i, line := range s.lines {
+		if i == 0 || line != s.lines[i-1] {
+			kept = append(kept, line)
+		}
+	}
+	s.lines = kept
+}
+
+// writeRun creates a run and writes lines to it with fn
+func (s *Sorter) writeRun(fn func(w *bufio.Writer) error) error {
+	file, err := os.CreateTemp(s.dir, "maskcat-sort-*")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, file.Name())
+
+	w := bufio.NewWriter(file)
+	if err := fn(w); err != nil {
+		file.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Each calls fn with every line in byte order and removes the runs
+//
+// The sorter is empty afterwards and can be reused. When fn returns an error
+// the remaining lines are skipped and the error is returned. When the sorter
+// removes duplicates fn is called once for each distinct line.
+//
+// Args:
+//
+//	fn (func(string) error): Function called with each line
+//
+// Returns:
+//
+//	(error): Error if any
+func (s *Sorter) Each(fn func(string) error) error {
+	defer s.Close()
+
+	if len(s.runs) == 0 {
+		s.sortLines()
+		for _, line := range s.lines {
+			if err := fn(line); err != nil {
+				return err
+			}
+		}
+		s.lines = s.lines[:0]
+		s.size = 0
+		return nil
+	}
+
+	if len(s.lines) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+
+	// Runs are merged in groups so the number of open files stays bounded
+	for len(s.runs) > maxRuns {
+		group := s.runs[:maxRuns]
+		s.runs = append([]string{}, s.runs[maxRuns:]...)
+		err := s.writeRun(func(w *bufio.Writer) error {
+			return s.merge(group, func(line string) error {
+				w.WriteString(line)
+				return w.WriteByte('\n')
+			})
+		})
+		removeAll(group)
+		if err != nil {
+			return err
+		}
+	}
+	return s.merge(s.runs, fn)
+}
+
+// Close removes any runs and empties the sorter
+//
+// Returns:
+//
+//	None
+func (s *Sorter) Close() {
+	removeAll(s.runs)
+	s.runs = nil
+	s.lines = s.lines[:0]
+	s.size = 0
+}
+
+// removeAll removes temporary files
+func removeAll(paths []string) {
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
+
+// mergeItem is the next line of a run
+type mergeItem struct {
+	line   string
+	reader *bufio.Reader
+}
+
+// mergeHeap orders runs by their next line
+type mergeHeap []*mergeItem
+
+func (h mergeHeap) Len() int           { return len(h) }
+func (h mergeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h mergeHeap) Less(i, j int) bool { return h[i].line < h[j].line }
+func (h *mergeHeap) Push(x any)        { *h = append(*h, x.(*mergeItem)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// readLine reads the next line of a run without its newline
+func readLine(r *bufio.Reader) (string, bool, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return "", false, nil
+	}
+	if err != nil && err != io.EOF {
+		return "", false, err
+	}
+	return strings.TrimSuffix(line, "\n"), true, nil
+}
+
+// merge calls fn with the lines of sorted runs in byte order
+func (s *Sorter) merge(runs []string, fn func(string) error) error {
+	h := &mergeHeap{}
+	for _, run := range runs {
+		file, err := os.Open(run)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		reader := bufio.NewReaderSize(file, 1<<16)
+		line, ok, err := readLine(reader)
+		if err != nil {
+			return err
+		}
+		if ok {
+			*h = append(*h, &mergeItem{line: line, reader: reader})
+		}
+	}
+	heap.Init(h)
+
+	last, started := "", false
+	for h.Len() > 0 {
+		item := (*h)[0]
+		if !s.unique || !started || item.line != last {
+			if err := fn(item.line); err != nil {
+				return err
+			}
+			last, started = item.line, true
+		}
+
+		line, ok, err := readLine(item.reader)
+		if err != nil {
+			return err
+		}
+		if ok {
+			item.line = line
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
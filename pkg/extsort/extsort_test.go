@@ -0,0 +1,87 @@
+package extsort
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSorter(t *testing.T) {
+	tests := []struct {
+		name   string
+		memory int
+		lines  int
+	}{
+		{"memory", DefaultMemory, 1000},
+		{"runs", 200, 1000},
+		{"cascade", 20, 1000},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		s := NewSorter(test.memory, dir, false)
+
+		want := []string{}
+		for i := 0; i < test.lines; i++ {
+			line := fmt.Sprintf("%d\xff%d", (i*7919)%test.lines, i%3)
+			want = append(want, line)
+			if err := s.Add(line); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sort.Strings(want)
+
+		got := []string{}
+		err := s.Each(func(line string) error {
+			got = append(got, line)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Each() did not return the lines in byte order", test.name)
+		}
+
+		files, _ := os.ReadDir(dir)
+		if len(files) != 0 {
+			t.Errorf("%s: Each() left %d temporary files", test.name, len(files))
+		}
+	}
+}
+
+func TestSorterEmpty(t *testing.T) {
+	s := NewSorter(0, t.TempDir(), false)
+	err := s.Each(func(line string) error {
+		t.Errorf("Each() returned %q from an empty sorter", line)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSorterUnique(t *testing.T) {
+	for _, memory := range []int{DefaultMemory, 40} {
+		s := NewSorter(memory, t.TempDir(), true)
+		if err := s.AddLines(strings.NewReader("b\na\nb\n\nc\r\na\nb\nc\r")); err != nil {
+			t.Fatal(err)
+		}
+
+		got := []string{}
+		err := s.Each(func(line string) error {
+			got = append(got, line)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"", "a", "b", "c\r"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Each() with %d bytes of memory = %q; want %q", memory, got, want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Package keyboard contains keyboard layouts and keyboard walk detection
+//
+// The package structure is broken into two components:
+//
+// keyboard.go which contains the primary logic
+// keyboard_test.go which contains unit tests
+package keyboard
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultMinLength is the default minimum length of a keyboard walk
+const DefaultMinLength = 4
+
+// Layout is a keyboard layout used to find keyboard walks
+//
+// Rows are staggered like a physical keyboard so each key touches the keys
+// beside it, the key above it and to the right, and the key below it and to
+// the left. Shifted characters are placed on the same key as their
+// unshifted character.
+type Layout struct {
+	Name string
+	keys map[rune]key
+}
+
+// key is the position of a key on the keyboard
+type key struct {
+	row int
+	col int
+}
+
+// NewLayout creates a keyboard layout from rows of keys
+//
+// Args:
+//
+//	name (string): Name of the layout
+//	rows ([]string): Unshifted characters for each row from the top
+//	shifted ([]string): Shifted characters for each row from the top
+//
+// Returns:
+//
+//	(*Layout): Keyboard layout
+func NewLayout(name string, rows []string, shifted []string) *Layout {
+	l := &Layout{Name: name, keys: make(map[rune]key)}
+	for _, set := range [][]string{rows, shifted} {
+		for r, row := range set {
+			for c, char := range []rune(row) {
+				if _, ok := l.keys[char]; !ok {
+					l.keys[char] = key{row: r, col: c}
+				}
+			}
+		}
+	}
+	return l
+}
+
+// Layouts contains the supported keyboard layouts by name
+var Layouts = map[string]*Layout{
+	"qwerty": NewLayout("qwerty",
+		[]string{"1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./"},
+		[]string{"!@#$%^&*()_+", "QWERTYUIOP{}|", "ASDFGHJKL:\"", "ZXCVBNM<>?"}),
+	"qwertz": NewLayout("qwertz",
+		[]string{"1234567890ß´", "qwertzuiopü+", "asdfghjklöä#", "yxcvbnm,.-"},
+		[]string{"!\"§$%&/()=?`", "QWERTZUIOPÜ*", "ASDFGHJKLÖÄ'", "YXCVBNM;:_"}),
+	"azerty": NewLayout("azerty",
+		[]string{"&é\"'(-è_çà)=", "azertyuiop^$", "qsdfghjklmù*", "wxcvbn,;:!"},
+		[]string{"1234567890°+", "AZERTYUIOP¨£", "QSDFGHJKLM%µ", "WXCVBN?./§"}),
+}
+
+// Lookup finds a keyboard layout by name
+//
+// Args:
+//
+//	name (string): Name of the layout
+//
+// Returns:
+//
+//	(*Layout): Keyboard layout
+//	err (error): Error data
+func Lookup(name string) (*Layout, error) {
+	l, ok := Layouts[strings.ToLower(name)]
+	if !ok {
+		names := []string{}
+		for n := range Layouts {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown keyboard layout %q (%s)", name, strings.Join(names, ", "))
+	}
+	return l, nil
+}
+
+// Adjacent tests if two characters are on touching keys
+//
+// Args:
+//
+//	a (rune): First character
+//	b (rune): Second character
+//
+// Returns:
+//
+//	(bool): If the keys touch
+func (l *Layout) Adjacent(a rune, b rune) bool {
+	ka, okA := l.keys[a]
+	kb, okB := l.keys[b]
+	if !okA || !okB {
+		return false
+	}
+
+	switch kb.row - ka.row {
+	case 0:
+		return kb.col == ka.col-1 || kb.col == ka.col+1
+	case -1:
+		return kb.col == ka.col || kb.col == ka.col+1
+	case 1:
+		return kb.col == ka.col-1 || kb.col == ka.col
+	}
+	return false
+}
+
+// Walks finds the keyboard walks in a string
+//
+// A walk is a run of characters where each character is on a key touching
+// the previous one and no key is pressed twice. This keeps words such as
+// "were" from being found as walks.
+//
+// Args:
+//
+//	str (string): Input string
+//	minLen (int): Minimum number of characters in a walk
+//
+// Returns:
+//
+//	walks ([]string): Walks in order of appearance
+func (l *Layout) Walks(str string, minLen int) []string {
+	walks := []string{}
+	runes := []rune(str)
+	start := 0
+	pressed := make(map[key]struct{})
+
+	emit := func(end int) {
+		if end-start >= minLen {
+			walks = append(walks, string(runes[start:end]))
+		}
+	}
+
+	for i, r := range runes {
+		k, ok := l.keys[r]
+		if i > start && ok && l.Adjacent(runes[i-1], r) {
+			if _, repeat := pressed[k]; !repeat {
+				pressed[k] = struct{}{}
+				continue
+			}
+		}
+
+		// The walk is broken so a new one starts here or at the previous key
+		// when the break was a key being pressed again
+		emit(i)
+		pressed = make(map[key]struct{})
+		start = i
+		if i > 0 && ok && l.Adjacent(runes[i-1], r) {
+			start = i - 1
+			pressed[l.keys[runes[i-1]]] = struct{}{}
+		}
+		if ok {
+			pressed[k] = struct{}{}
+		}
+	}
+	emit(len(runes))
+	return walks
+}
+
+// HasWalk tests if a string contains a keyboard walk
+//
+// Args:
+//
+//	str (string): Input string
+//	minLen (int): Minimum number of characters in a walk
+//
+// Returns:
+//
+//	(bool): If the string contains a walk
+func (l *Layout) HasWalk(str string, minLen int) bool {
+	return len(l.Walks(str, minLen)) > 0
+}
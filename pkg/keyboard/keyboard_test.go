@@ -0,0 +1,75 @@
+package keyboard
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	for _, name := range []string{"qwerty", "QWERTZ", "azerty"} {
+		if _, err := Lookup(name); err != nil {
+			t.Errorf("Lookup(%q) returned %v", name, err)
+		}
+	}
+	if _, err := Lookup("dvorak"); err == nil {
+		t.Errorf("Lookup(%q) did not return an error", "dvorak")
+	}
+}
+
+func TestAdjacent(t *testing.T) {
+	tests := []struct {
+		a        rune
+		b        rune
+		expected bool
+	}{
+		{'q', 'w', true},
+		{'q', '1', true},
+		{'q', '2', true},
+		{'q', 'a', true},
+		{'a', 'z', true},
+		{'s', 'z', true},
+		{'z', 's', true},
+		{'q', 'e', false},
+		{'q', 'Q', false},
+		{'!', 'Q', true},
+		{'a', 'x', false},
+	}
+
+	l := Layouts["qwerty"]
+	for _, test := range tests {
+		if got := l.Adjacent(test.a, test.b); got != test.expected {
+			t.Errorf("Adjacent(%q, %q) = %v; want %v", test.a, test.b, got, test.expected)
+		}
+	}
+}
+
+func TestWalks(t *testing.T) {
+	tests := []struct {
+		layout   string
+		input    string
+		expected []string
+	}{
+		{"qwerty", "qwerty123", []string{"qwerty"}},
+		{"qwerty", "1qaz2wsx", []string{"1qaz", "2wsx"}},
+		{"qwerty", "!QAZ@wsx", []string{"!QAZ", "@wsx"}},
+		{"qwerty", "zxcvbnm", []string{"zxcvbnm"}},
+		{"qwerty", "asdfdsa", []string{"asdf", "fdsa"}},
+		{"qwerty", "were", []string{}},
+		{"qwerty", "password", []string{}},
+		{"qwertz", "qwertz", []string{"qwertz"}},
+		{"qwertz", "yxcv", []string{"yxcv"}},
+		{"azerty", "azerty", []string{"azerty"}},
+		{"azerty", "1aqw", []string{"1aqw"}},
+	}
+
+	for _, test := range tests {
+		got := Layouts[test.layout].Walks(test.input, DefaultMinLength)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Walks(%q) on %s = %q; want %q", test.input, test.layout, got, test.expected)
+		}
+	}
+
+	if !Layouts["qwerty"].HasWalk("1qaz2wsx", DefaultMinLength) || Layouts["qwerty"].HasWalk("Summer24", DefaultMinLength) {
+		t.Errorf("HasWalk returned the wrong result")
+	}
+}
@@ -0,0 +1,284 @@
+// Package markov contains per-position Markov chain statistics in the
+// hashcat .hcstat2 format
+//
+// The package structure is broken into two components:
+//
+// markov.go which contains the primary logic
+// markov_test.go which contains unit tests
+package markov
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Version is the first value of an .hcstat2 file
+const Version uint64 = 0x6863737461740002
+
+// PasswordMax is the number of positions in an .hcstat2 file
+const PasswordMax = 256
+
+// CharSize is the number of byte values in an .hcstat2 file
+const CharSize = 256
+
+// dictCap is the LZMA2 dictionary size used to read and write files and
+// matches the dictionary size hashcat expects
+const dictCap = 64 << 20
+
+// row holds the counts of each byte and their total
+type row struct {
+	counts [CharSize]uint64
+	total  uint64
+}
+
+// Stats holds the counts of each byte at each position and of each byte
+// following another byte at each position
+type Stats struct {
+	root   [PasswordMax]row
+	markov map[int]*row
+}
+
+// NewStats creates empty statistics
+//
+// Returns:
+//
+//	(*Stats): Empty statistics
+func NewStats() *Stats {
+	return &Stats{markov: make(map[int]*row)}
+}
+
+// key returns the key of the byte that follows prev at a position
+func key(pos int, prev byte) int {
+	return pos<<8 | int(prev)
+}
+
+// add adds to the count of a byte in a row
+func (r *row) add(c byte, n uint64) {
+	r.counts[c] += n
+	r.total += n
+}
+
+// Add adds the bytes of a string to the statistics
+//
+// Like hashcat only the first PasswordMax bytes of a string are counted.
+//
+// Args:
+//
+//	str (string): Input string
+//
+// Returns:
+//
+//	None
+func (s *Stats) Add(str string) {
+	if len(str) > PasswordMax {
+		str = str[:PasswordMax]
+	}
+
+	for i := 0; i < len(str); i++ {
+		s.root[i].add(str[i], 1)
+		if i+1 < len(str) {
+			s.next(i, str[i]).add(str[i+1], 1)
+		}
+	}
+}
+
+// next returns the row of bytes following prev at a position creating it if
+// needed
+func (s *Stats) next(pos int, prev byte) *row {
+	r, ok := s.markov[key(pos, prev)]
+	if !ok {
+		r = &row{}
+		s.markov[key(pos, prev)] = r
+	}
+	return r
+}
+
+// Count returns how often next followed prev at a position
+//
+// Args:
+//
+//	pos (int): Position of prev
+//	prev (byte): Previous byte
+//	next (byte): Next byte
+//
+// Returns:
+//
+//	(uint64): Count
+func (s *Stats) Count(pos int, prev byte, next byte) uint64 {
+	if r, ok := s.markov[key(pos, prev)]; ok {
+		return r.counts[next]
+	}
+	return 0
+}
+
+// RootCount returns how often a byte was seen at a position
+//
+// Args:
+//
+//	pos (int): Position
+//	c (byte): Byte
+//
+// Returns:
+//
+//	(uint64): Count
+func (s *Stats) RootCount(pos int, c byte) uint64 {
+	if pos < 0 || pos >= PasswordMax {
+		return 0
+	}
+	return s.root[pos].counts[c]
+}
+
+// Score returns the log probability of a string under the statistics
+//
+// The first byte is scored by how often it started a string and each
+// following byte by how often it followed the byte before it at that
+// position. Counts are smoothed by adding one so unseen bytes lower the score
+// rather than ruling the string out. Higher scores are more likely.
+//
+// Args:
+//
+//	str (string): Input string
+//
+// Returns:
+//
+//	score (float64): Natural log probability
+func (s *Stats) Score(str string) float64 {
+	if len(str) > PasswordMax {
+		str = str[:PasswordMax]
+	}
+
+	score := 0.0
+	for i := 0; i < len(str); i++ {
+		var r *row
+		if i == 0 {
+			r = &s.root[0]
+		} else {
+			r = s.markov[key(i-1, str[i-1])]
+		}
+
+		count, total := uint64(0), uint64(0)
+		if r != nil {
+			count, total = r.counts[str[i]], r.total
+		}
+		score += math.Log(float64(count+1) / float64(total+CharSize))
+	}
+	return score
+}
+
+// Write writes the statistics as an LZMA2 compressed .hcstat2 file
+//
+// The file is a version value, a zero value, the root counts by position and
+// byte and the Markov counts by position, previous byte and next byte. Every
+// value is a big endian unsigned 64 bit integer.
+//
+// Args:
+//
+//	w (io.Writer): Output writer
+//
+// Returns:
+//
+//	(error): Error if any
+func (s *Stats) Write(w io.Writer) error {
+	compressor, err := lzma.Writer2Config{DictCap: dictCap}.NewWriter2(w)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(compressor)
+	values := make([]byte, CharSize*8)
+	writeRow := func(r *row) error {
+		clear(values)
+		if r != nil {
+			for c, count := range r.counts {
+				binary.BigEndian.PutUint64(values[c*8:], count)
+			}
+		}
+		_, err := out.Write(values)
+		return err
+	}
+
+	if err := binary.Write(out, binary.BigEndian, []uint64{Version, 0}); err != nil {
+		return err
+	}
+	for pos := range s.root {
+		if err := writeRow(&s.root[pos]); err != nil {
+			return err
+		}
+	}
+	for pos := 0; pos < PasswordMax; pos++ {
+		for prev := 0; prev < CharSize; prev++ {
+			if err := writeRow(s.markov[key(pos, byte(prev))]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	return compressor.Close()
+}
+
+// ReadStats reads statistics from an LZMA2 compressed .hcstat2 file
+//
+// Args:
+//
+//	r (io.Reader): Input reader
+//
+// Returns:
+//
+//	(*Stats): Statistics
+//	(error): Error if any
+func ReadStats(r io.Reader) (*Stats, error) {
+	decompressor, err := lzma.Reader2Config{DictCap: dictCap}.NewReader2(r)
+	if err != nil {
+		return nil, err
+	}
+
+	in := bufio.NewReader(decompressor)
+	header := make([]uint64, 2)
+	if err := binary.Read(in, binary.BigEndian, header); err != nil {
+		return nil, errors.New("file is not an hcstat2 file")
+	}
+	if header[0] != Version || header[1] != 0 {
+		return nil, errors.New("file is not an hcstat2 file")
+	}
+
+	s := NewStats()
+	values := make([]byte, CharSize*8)
+	readRow := func() (*row, error) {
+		if _, err := io.ReadFull(in, values); err != nil {
+			return nil, errors.New("hcstat2 file is truncated")
+		}
+		r := &row{}
+		for c := range r.counts {
+			r.add(byte(c), binary.BigEndian.Uint64(values[c*8:]))
+		}
+		return r, nil
+	}
+
+	for pos := range s.root {
+		r, err := readRow()
+		if err != nil {
+			return nil, err
+		}
+		s.root[pos] = *r
+	}
+	for pos := 0; pos < PasswordMax; pos++ {
+		for prev := 0; prev < CharSize; prev++ {
+			r, err := readRow()
+			if err != nil {
+				return nil, err
+			}
+			if r.total != 0 {
+				s.markov[key(pos, byte(prev))] = r
+			}
+		}
+	}
+	return s, nil
+}
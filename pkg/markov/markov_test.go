@@ -0,0 +1,98 @@
+package markov
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+func TestAdd(t *testing.T) {
+	s := NewStats()
+	for _, line := range []string{"pass", "past", "ab"} {
+		s.Add(line)
+	}
+
+	tests := []struct {
+		pos      int
+		prev     byte
+		next     byte
+		expected uint64
+	}{
+		{0, 'p', 'a', 2},
+		{1, 'a', 's', 2},
+		{2, 's', 's', 1},
+		{2, 's', 't', 1},
+		{0, 'a', 'b', 1},
+		{1, 'a', 'b', 0},
+	}
+
+	for _, test := range tests {
+		if got := s.Count(test.pos, test.prev, test.next); got != test.expected {
+			t.Errorf("Count(%d, %q, %q) = %d; want %d", test.pos, test.prev, test.next, got, test.expected)
+		}
+	}
+	if s.RootCount(0, 'p') != 2 || s.RootCount(3, 't') != 1 || s.RootCount(PasswordMax, 'p') != 0 {
+		t.Errorf("RootCount() returned the wrong counts")
+	}
+}
+
+func TestScore(t *testing.T) {
+	s := NewStats()
+	for _, line := range []string{"password", "password1", "passw0rd", "letmein"} {
+		s.Add(line)
+	}
+
+	if s.Score("password") <= s.Score("drowssap") {
+		t.Errorf("Score(password) = %f is not above Score(drowssap) = %f", s.Score("password"), s.Score("drowssap"))
+	}
+	if s.Score("") != 0 {
+		t.Errorf("Score(\"\") = %f; want 0", s.Score(""))
+	}
+}
+
+func TestStatsRoundTrip(t *testing.T) {
+	s := NewStats()
+	for _, line := range []string{"Summer24!", "Winter24!", "\xc3\xbcber"} {
+		s.Add(line)
+	}
+
+	var buf bytes.Buffer
+	if err := s.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// The decompressed file has the size and header hashcat expects
+	r, err := lzma.NewReader2(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 16+PasswordMax*CharSize*8+PasswordMax*CharSize*CharSize*8 {
+		t.Errorf("Write() wrote %d bytes", len(raw))
+	}
+	if binary.BigEndian.Uint64(raw) != Version || binary.BigEndian.Uint64(raw[8:]) != 0 {
+		t.Errorf("Write() wrote the header %x", raw[:16])
+	}
+	if binary.BigEndian.Uint64(raw[16+('S'*8):]) != 1 {
+		t.Errorf("Write() did not write root counts in big endian")
+	}
+
+	read, err := ReadStats(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, s) {
+		t.Errorf("ReadStats() did not read the statistics that were written")
+	}
+
+	if _, err := ReadStats(bytes.NewReader([]byte("not an hcstat2 file"))); err == nil {
+		t.Errorf("ReadStats() did not return an error for an invalid file")
+	}
+}
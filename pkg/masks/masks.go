@@ -0,0 +1,660 @@
+// Package masks contains hashcat mask parsing and indexed mask matching
+//
+// The package structure is broken into two components:
+//
+// masks.go which contains the primary logic
+// masks_test.go which contains unit tests and benchmarks
+package masks
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jakewnuk/maskcat/pkg/models"
+)
+
+// Charset is a set of bytes that a single mask position can match
+type Charset [4]uint64
+
+// Add adds a byte to the charset
+//
+// Args:
+//
+//	b (byte): Byte to add
+//
+// Returns:
+//
+//	None
+func (c *Charset) Add(b byte) {
+	c[b/64] |= 1 << (b % 64)
+}
+
+// Has tests if a byte is in the charset
+//
+// Args:
+//
+//	b (byte): Byte to test
+//
+// Returns:
+//
+//	(bool): If the byte is in the charset
+func (c Charset) Has(b byte) bool {
+	return c[b/64]&(1<<(b%64)) != 0
+}
+
+// Size returns the number of bytes in the charset
+//
+// Returns:
+//
+//	size (int): Number of bytes in the charset
+func (c Charset) Size() int {
+	size := 0
+	for _, word := range c {
+		for ; word != 0; word &= word - 1 {
+			size++
+		}
+	}
+	return size
+}
+
+// Union adds every byte of another charset to the charset
+//
+// Args:
+//
+//	other (Charset): Charset to add
+//
+// Returns:
+//
+//	None
+func (c *Charset) Union(other Charset) {
+	for i := range c {
+		c[i] |= other[i]
+	}
+}
+
+// Bytes returns the bytes in the charset in ascending order
+//
+// Returns:
+//
+//	bytes ([]byte): Bytes in the charset
+func (c Charset) Bytes() []byte {
+	bytes := []byte{}
+	for i := 0; i < 256; i++ {
+		if c.Has(byte(i)) {
+			bytes = append(bytes, byte(i))
+		}
+	}
+	return bytes
+}
+
+// NewCharset creates a charset containing every byte of a string
+//
+// Args:
+//
+//	str (string): Bytes to add to the charset
+//
+// Returns:
+//
+//	c (Charset): New charset
+func NewCharset(str string) Charset {
+	var c Charset
+	for i := 0; i < len(str); i++ {
+		c.Add(str[i])
+	}
+	return c
+}
+
+// byteRange creates a charset containing every byte between two bytes
+func byteRange(lo byte, hi byte) Charset {
+	var c Charset
+	for b := int(lo); b <= int(hi); b++ {
+		c.Add(byte(b))
+	}
+	return c
+}
+
+// Builtin holds the hashcat built-in charsets by their mask character
+var Builtin = func() map[byte]Charset {
+	lower := byteRange('a', 'z')
+	upper := byteRange('A', 'Z')
+	digit := byteRange('0', '9')
+	special := NewCharset(" !\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~")
+
+	all := lower
+	all.Union(upper)
+	all.Union(digit)
+	all.Union(special)
+
+	hexLower := digit
+	hexLower.Union(byteRange('a', 'f'))
+	hexUpper := digit
+	hexUpper.Union(byteRange('A', 'F'))
+
+	return map[byte]Charset{
+		'l': lower,
+		'u': upper,
+		'd': digit,
+		's': special,
+		'a': all,
+		'b': byteRange(0x00, 0xff),
+		'h': hexLower,
+		'H': hexUpper,
+	}
+}()
+
+// ParseCharset parses a custom charset definition such as "?l?d_-"
+//
+// Definitions can contain built-in charsets, literal bytes and ?? for a
+// literal question mark.
+//
+// Args:
+//
+//	def (string): Charset definition
+//
+// Returns:
+//
+//	c (Charset): Parsed charset
+//	err (error): Error data
+func ParseCharset(def string) (Charset, error) {
+	var c Charset
+	for i := 0; i < len(def); i++ {
+		if def[i] != '?' {
+			c.Add(def[i])
+			continue
+		}
+
+		if i+1 >= len(def) {
+			return c, fmt.Errorf("charset %q ends with '?'", def)
+		}
+		i++
+		if def[i] == '?' {
+			c.Add('?')
+			continue
+		}
+
+		builtin, ok := Builtin[def[i]]
+		if !ok {
+			return c, fmt.Errorf("charset %q contains invalid class ?%c", def, def[i])
+		}
+		c.Union(builtin)
+	}
+	return c, nil
+}
+
+// ReadCharsetFile reads a hashcat .hcchr charset file
+//
+// The file contents are parsed as a charset definition so .hcchr files can
+// hold raw bytes such as language specific characters. A trailing newline is
+// ignored.
+//
+// Args:
+//
+//	path (string): File path of the .hcchr file
+//
+// Returns:
+//
+//	c (Charset): Parsed charset
+//	err (error): Error data
+func ReadCharsetFile(path string) (Charset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Charset{}, err
+	}
+
+	def := strings.TrimRight(string(data), "\r\n")
+	if def == "" {
+		return Charset{}, fmt.Errorf("charset file %q is empty", path)
+	}
+	return ParseCharset(def)
+}
+
+// LoadCharset loads a custom charset from a .hcchr file or a definition
+//
+// Like hashcat the argument is read as a file when one exists at the path
+// and is otherwise parsed as a charset definition such as "?l?d_-".
+//
+// Args:
+//
+//	arg (string): File path or charset definition
+//
+// Returns:
+//
+//	c (Charset): Parsed charset
+//	err (error): Error data
+func LoadCharset(arg string) (Charset, error) {
+	if info, err := os.Stat(arg); err == nil && !info.IsDir() {
+		return ReadCharsetFile(arg)
+	}
+	return ParseCharset(arg)
+}
+
+// ParseMask parses a mask into the charset for each position
+//
+// Masks can contain built-in charsets, custom charsets ?1 to ?4, literal
+// bytes and ?? for a literal question mark.
+//
+// Args:
+//
+//	mask (string): Mask to parse
+//	custom (map[byte]Charset): Custom charsets keyed by '1' to '4'
+//
+// Returns:
+//
+//	positions ([]Charset): Charset for each position
+//	err (error): Error data
+func ParseMask(mask string, custom map[byte]Charset) ([]Charset, error) {
+	positions := []Charset{}
+	for i := 0; i < len(mask); i++ {
+		if mask[i] != '?' {
+			var c Charset
+			c.Add(mask[i])
+			positions = append(positions, c)
+			continue
+		}
+
+		if i+1 >= len(mask) {
+			return nil, fmt.Errorf("mask %q ends with '?'", mask)
+		}
+		i++
+
+		switch class := mask[i]; {
+		case class == '?':
+			var c Charset
+			c.Add('?')
+			positions = append(positions, c)
+		case class >= '1' && class <= '4':
+			c, ok := custom[class]
+			if !ok {
+				return nil, fmt.Errorf("mask %q uses undefined custom charset ?%c", mask, class)
+			}
+			positions = append(positions, c)
+		default:
+			c, ok := Builtin[class]
+			if !ok {
+				return nil, fmt.Errorf("mask %q contains invalid class ?%c", mask, class)
+			}
+			positions = append(positions, c)
+		}
+	}
+	return positions, nil
+}
+
+// ParseHCMaskLine parses a line from a .hcmask file
+//
+// Lines are made of up to four comma separated custom charsets followed by
+// the mask. Commas and backslashes can be escaped with a backslash. Custom
+// charsets given in the line are added to a copy of the provided custom
+// charsets.
+//
+// Args:
+//
+//	line (string): Line from a .hcmask file
+//	custom (map[byte]Charset): Custom charsets keyed by '1' to '4'
+//
+// Returns:
+//
+//	mask (string): Mask from the line
+//	charsets (map[byte]Charset): Custom charsets for the mask
+//	err (error): Error data
+func ParseHCMaskLine(line string, custom map[byte]Charset) (string, map[byte]Charset, error) {
+	fields := []string{}
+	current := strings.Builder{}
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && (line[i+1] == ',' || line[i+1] == '\\'):
+			current.WriteByte(line[i+1])
+			i++
+		case line[i] == ',':
+			fields = append(fields, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(line[i])
+		}
+	}
+	fields = append(fields, current.String())
+
+	if len(fields) > 5 {
+		return "", nil, fmt.Errorf("line %q has more than four custom charsets", line)
+	}
+
+	charsets := make(map[byte]Charset)
+	for k, v := range custom {
+		charsets[k] = v
+	}
+	for i, def := range fields[:len(fields)-1] {
+		c, err := ParseCharset(def)
+		if err != nil {
+			return "", nil, err
+		}
+		charsets[byte('1'+i)] = c
+	}
+
+	return fields[len(fields)-1], charsets, nil
+}
+
+// EscapeHCMask escapes the commas and backslashes of a mask so it can be
+// written as the mask of a .hcmask line
+//
+// Masks that mix literal text with mask tokens such as partial and retain
+// masks can contain commas that would otherwise be read as custom charsets
+// and backslashes that would otherwise escape the byte after them.
+//
+// Args:
+//
+//	mask (string): Mask that can contain literal commas
+//
+// Returns:
+//
+//	(string): Mask with every comma and backslash escaped with a backslash
+func EscapeHCMask(mask string) string {
+	return hcmaskEscaper.Replace(mask)
+}
+
+// hcmaskEscaper escapes the bytes ParseHCMaskLine reads as escapes
+var hcmaskEscaper = strings.NewReplacer("\\", "\\\\", ",", "\\,")
+
+// Keyspace calculates the number of candidates a parsed mask produces
+//
+// Args:
+//
+//	positions ([]Charset): Charset for each position
+//
+// Returns:
+//
+//	keyspace (float64): Number of candidates
+func Keyspace(positions []Charset) float64 {
+	keyspace := 1.0
+	for _, c := range positions {
+		keyspace *= float64(c.Size())
+	}
+	return keyspace
+}
+
+// Index matches plaintext against many masks without testing each mask
+//
+// Masks using only ?l, ?u, ?d and ?s are stored in a hash set keyed by the
+// mask since every printable byte belongs to exactly one of those classes so
+// they are matched with one lookup. All other masks are stored in a trie
+// where each edge is the charset of one position. The trie search follows
+// every edge whose charset has the next byte so when the charsets of sibling
+// edges overlap, such as ?a and ?l, it branches. The worst case visits every
+// trie node no deeper than the plaintext, which is bounded by the total length
+// of the masks in the trie, and tests every edge of each visited node.
+type Index struct {
+	exact map[string][]int
+	root  *trieNode
+	size  int
+}
+
+type trieNode struct {
+	children map[Charset]*trieNode
+	order    []Charset
+	ids      []int
+}
+
+// NewIndex creates an empty Index
+//
+// Returns:
+//
+//	(*Index): Empty index
+func NewIndex() *Index {
+	return &Index{exact: make(map[string][]int), root: &trieNode{}}
+}
+
+// Len returns the number of masks in the index
+//
+// Returns:
+//
+//	(int): Number of masks
+func (x *Index) Len() int {
+	return x.size
+}
+
+// Add adds a mask to the index
+//
+// Args:
+//
+//	mask (string): Mask to add
+//	custom (map[byte]Charset): Custom charsets keyed by '1' to '4'
+//	id (int): Identifier returned when the mask matches such as a line number
+//
+// Returns:
+//
+//	err (error): Error data
+func (x *Index) Add(mask string, custom map[byte]Charset, id int) error {
+	positions, err := ParseMask(mask, custom)
+	if err != nil {
+		return err
+	}
+	if len(positions) == 0 {
+		return fmt.Errorf("mask is empty")
+	}
+	x.size++
+
+	if isExactMask(mask) {
+		x.exact[mask] = append(x.exact[mask], id)
+		return nil
+	}
+
+	node := x.root
+	for _, c := range positions {
+		if node.children == nil {
+			node.children = make(map[Charset]*trieNode)
+		}
+		child, ok := node.children[c]
+		if !ok {
+			child = &trieNode{}
+			node.children[c] = child
+			node.order = append(node.order, c)
+		}
+		node = child
+	}
+	node.ids = append(node.ids, id)
+	return nil
+}
+
+// Match finds the mask with the lowest id that matches a plaintext
+//
+// Args:
+//
+//	plaintext (string): Plaintext to match
+//
+// Returns:
+//
+//	id (int): Id of the matching mask
+//	ok (bool): If any mask matched
+func (x *Index) Match(plaintext string) (int, bool) {
+	id, ok := -1, false
+	x.match(plaintext, func(ids []int) {
+		if !ok || ids[0] < id {
+			id, ok = ids[0], true
+		}
+	})
+	return id, ok
+}
+
+// MatchAll finds every mask that matches a plaintext
+//
+// Args:
+//
+//	plaintext (string): Plaintext to match
+//
+// Returns:
+//
+//	ids ([]int): Ids of the matching masks in ascending order
+func (x *Index) MatchAll(plaintext string) []int {
+	ids := []int{}
+	x.match(plaintext, func(matched []int) {
+		ids = append(ids, matched...)
+	})
+	sort.Ints(ids)
+	return ids
+}
+
+// match calls found with the ids of every mask that matches a plaintext
+func (x *Index) match(plaintext string, found func([]int)) {
+	if key, ok := exactKey(plaintext); ok {
+		if ids, ok := x.exact[key]; ok {
+			found(ids)
+		}
+	}
+
+	type state struct {
+		node *trieNode
+		pos  int
+	}
+	stack := []state{{node: x.root}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if s.pos == len(plaintext) {
+			if len(s.node.ids) > 0 {
+				found(s.node.ids)
+			}
+			continue
+		}
+
+		b := plaintext[s.pos]
+		for _, c := range s.node.order {
+			if c.Has(b) {
+				stack = append(stack, state{node: s.node.children[c], pos: s.pos + 1})
+			}
+		}
+	}
+}
+
+// isExactMask tests if a mask only uses the disjoint ?l, ?u, ?d and ?s
+// classes
+func isExactMask(mask string) bool {
+	if len(mask)%2 != 0 {
+		return false
+	}
+	for i := 0; i < len(mask); i += 2 {
+		if mask[i] != '?' || strings.IndexByte("luds", mask[i+1]) == -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// exactKey converts a plaintext into its ?l?u?d?s mask
+func exactKey(plaintext string) (string, bool) {
+	key := make([]byte, 0, len(plaintext)*2)
+	for i := 0; i < len(plaintext); i++ {
+		b := plaintext[i]
+		switch {
+		case b >= 'a' && b <= 'z':
+			key = append(key, '?', 'l')
+		case b >= 'A' && b <= 'Z':
+			key = append(key, '?', 'u')
+		case b >= '0' && b <= '9':
+			key = append(key, '?', 'd')
+		case b >= ' ' && b <= '~':
+			key = append(key, '?', 's')
+		default:
+			return "", false
+		}
+	}
+	return string(key), true
+}
+
+// Coverage accumulates how many plaintexts each mask of an index covers
+//
+// Masks are identified by their position in the mask file so ids given to
+// the index should be 0 to n-1.
+type Coverage struct {
+	hits  []int
+	first []int
+	total int
+}
+
+// NewCoverage creates a Coverage for n masks
+//
+// Args:
+//
+//	n (int): Number of masks
+//
+// Returns:
+//
+//	(*Coverage): Empty coverage
+func NewCoverage(n int) *Coverage {
+	return &Coverage{hits: make([]int, n), first: make([]int, n)}
+}
+
+// Add records the masks that matched one plaintext
+//
+// Args:
+//
+//	ids ([]int): Ids of the matching masks in ascending order
+//
+// Returns:
+//
+//	None
+func (c *Coverage) Add(ids []int) {
+	c.total++
+	for _, id := range ids {
+		c.hits[id]++
+	}
+	if len(ids) > 0 {
+		c.first[ids[0]]++
+	}
+}
+
+// Report creates coverage statistics for every mask in file order
+//
+// Hits counts every plaintext a mask matches while new hits only counts
+// plaintexts not matched by an earlier mask. The cumulative percentage is the
+// share of all plaintexts covered once the mask and every mask before it has
+// run. Masks are ranked by hits per keyspace.
+//
+// Args:
+//
+//	entries ([]models.MaskEntry): Masks in file order
+//
+// Returns:
+//
+//	report ([]models.MaskCoverage): Coverage of each mask
+func (c *Coverage) Report(entries []models.MaskEntry) []models.MaskCoverage {
+	report := make([]models.MaskCoverage, len(entries))
+	covered := 0
+	for i, entry := range entries {
+		covered += c.first[i]
+		report[i] = models.MaskCoverage{
+			Mask:     entry.Mask,
+			Line:     entry.Line,
+			Hits:     c.hits[i],
+			NewHits:  c.first[i],
+			Keyspace: entry.Keyspace,
+		}
+		if c.total > 0 {
+			report[i].Cumulative = float64(covered) / float64(c.total) * 100
+		}
+		if entry.Keyspace > 0 {
+			report[i].Efficiency = float64(c.hits[i]) / entry.Keyspace
+		}
+	}
+
+	ranked := make([]int, len(report))
+	for i := range ranked {
+		ranked[i] = i
+	}
+	sort.SliceStable(ranked, func(a, b int) bool {
+		return report[ranked[a]].Efficiency > report[ranked[b]].Efficiency
+	})
+	for rank, i := range ranked {
+		report[i].Rank = rank + 1
+	}
+	return report
+}
+
+// Total returns the number of plaintexts recorded
+//
+// Returns:
+//
+//	(int): Number of plaintexts
+func (c *Coverage) Total() int {
+	return c.total
+}
@@ -0,0 +1,299 @@
+package masks
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jakewnuk/maskcat/pkg/models"
+)
+
+func TestCharset(t *testing.T) {
+	c := NewCharset("abc")
+	if !c.Has('a') || c.Has('d') {
+		t.Errorf("NewCharset(%q) membership is wrong", "abc")
+	}
+	if c.Size() != 3 {
+		t.Errorf("NewCharset(%q).Size() = %d; want 3", "abc", c.Size())
+	}
+
+	tests := []struct {
+		class byte
+		size  int
+	}{
+		{'l', 26},
+		{'u', 26},
+		{'d', 10},
+		{'s', 33},
+		{'a', 95},
+		{'b', 256},
+		{'h', 16},
+		{'H', 16},
+	}
+
+	for _, test := range tests {
+		if got := Builtin[test.class].Size(); got != test.size {
+			t.Errorf("Builtin[%q].Size() = %d; want %d", test.class, got, test.size)
+		}
+	}
+}
+
+func TestParseCharset(t *testing.T) {
+	tests := []struct {
+		input string
+		size  int
+		err   bool
+	}{
+		{"?l?d", 36, false},
+		{"abc", 3, false},
+		{"??", 1, false},
+		{"?x", 0, true},
+		{"abc?", 0, true},
+	}
+
+	for _, test := range tests {
+		c, err := ParseCharset(test.input)
+		if (err != nil) != test.err || (err == nil && c.Size() != test.size) {
+			t.Errorf("ParseCharset(%q) = (%d, %v); want (%d, err=%v)", test.input, c.Size(), err, test.size, test.err)
+		}
+	}
+}
+
+func TestLoadCharset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "german.hcchr")
+	if err := os.WriteFile(path, []byte("\xc3\xa4\xb6\xbc\x9f\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input string
+		size  int
+		err   bool
+	}{
+		{path, 5, false},
+		{"?l?d", 36, false},
+		{"?x", 0, true},
+	}
+
+	for _, test := range tests {
+		c, err := LoadCharset(test.input)
+		if (err != nil) != test.err || (err == nil && c.Size() != test.size) {
+			t.Errorf("LoadCharset(%q) = (%d, %v); want (%d, err=%v)", test.input, c.Size(), err, test.size, test.err)
+		}
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.hcchr")
+	if err := os.WriteFile(empty, []byte("\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadCharsetFile(empty); err == nil {
+		t.Errorf("ReadCharsetFile(%q) did not return an error", empty)
+	}
+}
+
+func TestParseMask(t *testing.T) {
+	custom := map[byte]Charset{'1': NewCharset("xyz")}
+	tests := []struct {
+		input  string
+		length int
+		err    bool
+	}{
+		{"?u?l?d?s", 4, false},
+		{"Summer?d?d", 8, false},
+		{"a??b", 3, false},
+		{"?1?1", 2, false},
+		{"?2", 0, true},
+		{"?q", 0, true},
+		{"?d?", 0, true},
+	}
+
+	for _, test := range tests {
+		positions, err := ParseMask(test.input, custom)
+		if (err != nil) != test.err || len(positions) != test.length {
+			t.Errorf("ParseMask(%q) = (%d positions, %v); want (%d, err=%v)", test.input, len(positions), err, test.length, test.err)
+		}
+	}
+}
+
+func TestParseHCMaskLine(t *testing.T) {
+	tests := []struct {
+		input string
+		mask  string
+		sets  int
+		err   bool
+	}{
+		{"?l?d?d", "?l?d?d", 0, false},
+		{"?l?d,?u,?1?2?1", "?1?2?1", 2, false},
+		{"\\,.,?1?1", "?1?1", 1, false},
+		{"A\\\\,?1?1", "?1?1", 1, false},
+		{"a,b,c,d,e,?1", "", 0, true},
+	}
+
+	for _, test := range tests {
+		mask, charsets, err := ParseHCMaskLine(test.input, nil)
+		if (err != nil) != test.err || mask != test.mask || len(charsets) != test.sets {
+			t.Errorf("ParseHCMaskLine(%q) = (%q, %d, %v); want (%q, %d, err=%v)", test.input, mask, len(charsets), err, test.mask, test.sets, test.err)
+		}
+	}
+
+	_, charsets, _ := ParseHCMaskLine("\\,.,?1?1", nil)
+	if !charsets['1'].Has(',') || !charsets['1'].Has('.') {
+		t.Errorf("ParseHCMaskLine did not unescape commas in custom charsets")
+	}
+	_, charsets, _ = ParseHCMaskLine("A\\\\,?1?1", nil)
+	if !charsets['1'].Has('\\') || charsets['1'].Size() != 2 {
+		t.Errorf("ParseHCMaskLine did not unescape backslashes in custom charsets")
+	}
+}
+
+func TestEscapeHCMask(t *testing.T) {
+	tests := []struct {
+		mask  string
+		match string
+	}{
+		{"ab,c?d", "ab,c1"},
+		{"a\\,b", "a\\,b"},
+		{",,?l", ",,x"},
+		{"ab\\", "ab\\"},
+		{"?l?d", "a1"},
+	}
+
+	for _, test := range tests {
+		line := EscapeHCMask(test.mask)
+		mask, charsets, err := ParseHCMaskLine(line, nil)
+		if err != nil || mask != test.mask || len(charsets) != 0 {
+			t.Errorf("ParseHCMaskLine(EscapeHCMask(%q)) = (%q, %d, %v); want (%q, 0, nil)", test.mask, mask, len(charsets), err, test.mask)
+			continue
+		}
+
+		x := NewIndex()
+		if err := x.Add(mask, charsets, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := x.Match(test.match); !ok {
+			t.Errorf("mask %q from line %q did not match %q", mask, line, test.match)
+		}
+	}
+}
+
+func TestIndexMatch(t *testing.T) {
+	x := NewIndex()
+	lines := []string{"?u?l?l?l?d?d", "?a?a?a?a?a?a", "?1?1?1", "?b?b", "Summer?d?d"}
+	custom := map[byte]Charset{'1': NewCharset("abc")}
+	for i, mask := range lines {
+		if err := x.Add(mask, custom, i+1); err != nil {
+			t.Fatalf("Index.Add(%q) returned %v", mask, err)
+		}
+	}
+
+	tests := []struct {
+		input string
+		id    int
+		ok    bool
+		all   []int
+	}{
+		{"Test12", 1, true, []int{1, 2}},
+		{"test12", 2, true, []int{2}},
+		{"cab", 3, true, []int{3}},
+		{"ab", 4, true, []int{4}},
+		{"über", 0, false, []int{}},
+		{"Summer24", 5, true, []int{5}},
+		{"Winter24", 0, false, []int{}},
+		{"", 0, false, []int{}},
+	}
+
+	for _, test := range tests {
+		id, ok := x.Match(test.input)
+		if ok != test.ok || (ok && id != test.id) {
+			t.Errorf("Index.Match(%q) = (%d, %v); want (%d, %v)", test.input, id, ok, test.id, test.ok)
+		}
+		if all := x.MatchAll(test.input); !reflect.DeepEqual(all, test.all) {
+			t.Errorf("Index.MatchAll(%q) = %v; want %v", test.input, all, test.all)
+		}
+	}
+}
+
+func TestKeyspace(t *testing.T) {
+	positions, _ := ParseMask("?l?d?d", nil)
+	if got := Keyspace(positions); got != 2600 {
+		t.Errorf("Keyspace(?l?d?d) = %v; want 2600", got)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	entries := []models.MaskEntry{
+		{Line: 1, Mask: "?d?d", Keyspace: 100},
+		{Line: 2, Mask: "?a?a", Keyspace: 9025},
+	}
+	c := NewCoverage(len(entries))
+	c.Add([]int{0, 1})
+	c.Add([]int{1})
+	c.Add([]int{})
+	c.Add([]int{0, 1})
+
+	report := c.Report(entries)
+	want := []models.MaskCoverage{
+		{Mask: "?d?d", Line: 1, Hits: 2, NewHits: 2, Cumulative: 50, Keyspace: 100, Efficiency: 0.02, Rank: 1},
+		{Mask: "?a?a", Line: 2, Hits: 3, NewHits: 1, Cumulative: 75, Keyspace: 9025, Efficiency: 3.0 / 9025, Rank: 2},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("Coverage.Report() = %v; want %v", report, want)
+	}
+	if c.Total() != 4 {
+		t.Errorf("Coverage.Total() = %d; want 4", c.Total())
+	}
+}
+
+// randomMasks creates n random masks between 6 and 12 positions long
+func randomMasks(n int, classes string) []string {
+	rng := rand.New(rand.NewSource(1))
+	masks := make([]string, n)
+	for i := range masks {
+		mask := ""
+		for j := 0; j < 6+rng.Intn(7); j++ {
+			mask += "?" + string(classes[rng.Intn(len(classes))])
+		}
+		masks[i] = mask
+	}
+	return masks
+}
+
+var benchmarkPlaintexts = []string{"Password123!", "summer2024", "Tr0ub4dor&3", "correcthorse", "letmein", "P@ssw0rd"}
+
+func benchmarkIndex(b *testing.B, classes string) {
+	x := NewIndex()
+	for i, mask := range randomMasks(50000, classes) {
+		if err := x.Add(mask, nil, i); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x.Match(benchmarkPlaintexts[i%len(benchmarkPlaintexts)])
+	}
+}
+
+func BenchmarkIndexExact50k(b *testing.B) {
+	benchmarkIndex(b, "luds")
+}
+
+func BenchmarkIndexTrie50k(b *testing.B) {
+	benchmarkIndex(b, "ludsah")
+}
+
+func BenchmarkLinear50k(b *testing.B) {
+	masks := randomMasks(50000, "luds")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key, _ := exactKey(benchmarkPlaintexts[i%len(benchmarkPlaintexts)])
+		for _, mask := range masks {
+			if mask == key {
+				break
+			}
+		}
+	}
+}
@@ -9,9 +9,79 @@ package models
 import (
 	"fmt"
 	"regexp"
+	"strings"
 	"unicode/utf8"
 )
 
+// TokenCount holds a token and the number of times it was seen
+type TokenCount struct {
+	Token string
+	Count int
+}
+
+// WeightedToken holds a token and its weight from a token file
+type WeightedToken struct {
+	Token  string
+	Weight float64
+}
+
+// GenerationOptions holds options that bound and partition the candidates
+// generated by the token swapping and enumeration modes
+type GenerationOptions struct {
+	// Limit is the max number of candidates to print (0 prints all)
+	Limit int
+	// MaxPerLine is the max number of candidates per input line (0 prints all)
+	MaxPerLine int
+	// Sample is the number of tokens randomly sampled per line (0 uses all)
+	Sample int
+	// Seed is the seed used for sampling
+	Seed int64
+	// Dedupe is the method used to remove duplicates ("", "exact" or "bloom")
+	Dedupe string
+	// DedupeSize is the expected number of unique candidates for "bloom"
+	DedupeSize int
+	// DedupeRate is the false positive rate for "bloom"
+	DedupeRate float64
+	// Skip is the number of candidates to skip before printing
+	Skip int
+	// Shard is the shard to print from 1 to Shards
+	Shard int
+	// Shards is the number of shards the candidates are split into
+	Shards int
+	// Keyspace prints the number of candidates instead of the candidates
+	Keyspace bool
+}
+
+// TemplatePart holds a part of a combinator template
+type TemplatePart struct {
+	// Text is the slot mask such as ?w or ?d?d?d?d or literal text
+	Text string
+	// Slot is true when Text is a slot filled by a token
+	Slot bool
+}
+
+// MaskEntry holds a mask loaded from a mask file
+type MaskEntry struct {
+	// Line is the line number of the mask in the file
+	Line int
+	// Mask is the mask without any custom charsets
+	Mask string
+	// Keyspace is the number of candidates the mask produces
+	Keyspace float64
+}
+
+// MaskCoverage holds how many plaintexts of a cracked set a mask covers
+type MaskCoverage struct {
+	Mask       string  `json:"mask"`
+	Line       int     `json:"line"`
+	Hits       int     `json:"hits"`
+	NewHits    int     `json:"new_hits"`
+	Cumulative float64 `json:"cumulative_percent"`
+	Keyspace   float64 `json:"keyspace"`
+	Efficiency float64 `json:"hits_per_keyspace"`
+	Rank       int     `json:"efficiency_rank"`
+}
+
 // IsHashMask tests a string to see if it contains only mask characters
 //
 // Args:
@@ -22,13 +92,103 @@ import (
 //
 //	(bool): If the string is a valid mask
 func IsHashMask(mask string) bool {
-	var IsMask = regexp.MustCompile(`^[uldsb?]+$`).MatchString
+	var IsMask = regexp.MustCompile(`^[uldsbahH?]+$`).MatchString
+	if IsMask(mask) == false {
+		return false
+	}
+	return true
+}
+
+// IsMaskOnly tests a string to see if it only contains mask tokens such as
+// ?l, ?u, ?d, ?s, ?a, ?b, ?h, ?H and custom charsets ?1 to ?4
+//
+// Args:
+//
+//	mask (string): The input string
+//
+// Returns:
+//
+//	(bool): If the string only contains mask tokens
+func IsMaskOnly(mask string) bool {
+	var IsMask = regexp.MustCompile(`^(\?[uldsabhH1-4])+$`).MatchString
 	if IsMask(mask) == false {
 		return false
 	}
 	return true
 }
 
+// IsPartialMask tests a string to see if it is a mask that can mix literal
+// text with mask tokens such as Summer?d?d?d?d
+//
+// Every ? must start a valid mask token or be escaped as ?? for a literal
+// question mark.
+//
+// Args:
+//
+//	mask (string): The input string
+//
+// Returns:
+//
+//	(bool): If the string is a valid partial mask
+func IsPartialMask(mask string) bool {
+	var IsMask = regexp.MustCompile(`^([^?]|\?[uldsabhH1-4?])+$`).MatchString
+	if IsMask(mask) == false {
+		return false
+	}
+	return true
+}
+
+// FindUntranslatableJohn finds constructs in a John the Ripper mask that
+// cannot be translated into a hashcat mask
+//
+// John supports placeholders such as ?w for hybrid words and ?L, ?U, ?D, ?S,
+// ?y, ?o and ?A for codepage aware classes that hashcat masks have no
+// equivalent for. Unterminated [ ] ranges are also reported.
+//
+// Args:
+//
+//	mask (string): John the Ripper mask
+//
+// Returns:
+//
+//	found ([]string): Untranslatable constructs in order of appearance
+func FindUntranslatableJohn(mask string) []string {
+	found := []string{}
+	seen := make(map[string]struct{})
+	add := func(construct string) {
+		if _, ok := seen[construct]; !ok {
+			seen[construct] = struct{}{}
+			found = append(found, construct)
+		}
+	}
+
+	inRange := false
+	for i := 0; i < len(mask); i++ {
+		switch mask[i] {
+		case '\\':
+			i++
+		case '[':
+			inRange = true
+		case ']':
+			inRange = false
+		case '?':
+			if i+1 >= len(mask) {
+				add("trailing ?")
+				continue
+			}
+			i++
+			if !strings.ContainsRune("ludsahHbB?123456789", rune(mask[i])) {
+				add("?" + string(mask[i]))
+			}
+		}
+	}
+
+	if inRange {
+		add("unterminated [")
+	}
+	return found
+}
+
 // IsStringInt tests a string to see if it only contains numerical characters
 //
 // Args:
@@ -63,6 +223,42 @@ func IsStringAlpha(str string) bool {
 	return true
 }
 
+// IsStringSpecial tests a string to see if it only contains special characters
+//
+// Args:
+//
+//	str (string): The input string
+//
+// Returns:
+//
+//	(bool) : If the string is a valid special only
+func IsStringSpecial(str string) bool {
+	var IsSpecial = regexp.MustCompile(`^[^a-zA-Z0-9\x80-\x{10FFFF}]+$`).MatchString
+	if IsSpecial(str) == false {
+		return false
+	}
+	return true
+}
+
+// IsTokenClass tests a string to see if it is a valid token class
+//
+// Token classes are "alpha", "digit", "special", "mixed" and "multibyte"
+//
+// Args:
+//
+//	str (string): The input string
+//
+// Returns:
+//
+//	(bool) : If the string is a valid token class
+func IsTokenClass(str string) bool {
+	var IsClass = regexp.MustCompile(`^(alpha|digit|special|mixed|multibyte)$`).MatchString
+	if IsClass(str) == false {
+		return false
+	}
+	return true
+}
+
 // IsStringASCII checks to see if a string only contains ASCII characters
 //
 // Args:
@@ -110,19 +306,15 @@ func EnsureValidMask(mask string) string {
 //	returnStr (string): Converted string
 func ConvertMultiByteString(str string) string {
 	returnStr := ""
-	for _, r := range str {
+	for i := 0; i < len(str); {
+		r, size := utf8.DecodeRuneInString(str[i:])
 		if r > 127 {
-			byteArr := []byte(string(r))
-			for j := range byteArr {
-				if j == len(byteArr)-1 {
-					returnStr += fmt.Sprintf("?b")
-				} else {
-					returnStr += fmt.Sprintf("?b")
-				}
-			}
+			// Invalid bytes are converted one at a time
+			returnStr += strings.Repeat("?b", size)
 		} else {
 			returnStr += fmt.Sprintf("%c", r)
 		}
+		i += size
 	}
 	return returnStr
 }
@@ -1,6 +1,9 @@
 package models
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestIsHashMask(t *testing.T) {
 	tests := []struct {
@@ -11,6 +14,8 @@ func TestIsHashMask(t *testing.T) {
 		{"", false},
 		{"abc", false},
 		{"uldsb", true},
+		{"?h?H?a", true},
+		{"?x", false},
 	}
 
 	for _, test := range tests {
@@ -21,6 +26,74 @@ func TestIsHashMask(t *testing.T) {
 	}
 }
 
+func TestIsMaskOnly(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"?u?l?d?s", true},
+		{"?a?b?h?H", true},
+		{"?1?2?3?4", true},
+		{"", false},
+		{"?5", false},
+		{"abc?d", false},
+		{"?d?", false},
+	}
+
+	for _, test := range tests {
+		result := IsMaskOnly(test.input)
+		if result != test.expected {
+			t.Errorf("IsMaskOnly(%q) = %v; want %v", test.input, result, test.expected)
+		}
+	}
+}
+
+func TestIsPartialMask(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"?u?l?d?s", true},
+		{"Summer?d?d?d?d", true},
+		{"?l?l??", true},
+		{"Über?d", true},
+		{"plain", true},
+		{"", false},
+		{"?x", false},
+		{"abc?", false},
+	}
+
+	for _, test := range tests {
+		result := IsPartialMask(test.input)
+		if result != test.expected {
+			t.Errorf("IsPartialMask(%q) = %v; want %v", test.input, result, test.expected)
+		}
+	}
+}
+
+func TestFindUntranslatableJohn(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"?l?u?d?s", []string{}},
+		{"[a-z]?d\\?", []string{}},
+		{"?w?d?d", []string{"?w"}},
+		{"?L?U?L", []string{"?L", "?U"}},
+		{"[?l?y]", []string{"?y"}},
+		{"??w", []string{}},
+		{"[abc", []string{"unterminated ["}},
+		{"?", []string{"trailing ?"}},
+	}
+
+	for _, test := range tests {
+		result := FindUntranslatableJohn(test.input)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("FindUntranslatableJohn(%q) = %q; want %q", test.input, result, test.expected)
+		}
+	}
+}
+
 func TestIsStringInt(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -59,6 +132,49 @@ func TestIsStringAlpha(t *testing.T) {
 	}
 }
 
+func TestIsStringSpecial(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"!@#", true},
+		{"", false},
+		{"-_ ", true},
+		{"abc!", false},
+		{"1!", false},
+		{"世!", false},
+	}
+
+	for _, test := range tests {
+		result := IsStringSpecial(test.input)
+		if result != test.expected {
+			t.Errorf("IsStringSpecial(%q) = %v; want %v", test.input, result, test.expected)
+		}
+	}
+}
+
+func TestIsTokenClass(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"alpha", true},
+		{"digit", true},
+		{"special", true},
+		{"mixed", true},
+		{"multibyte", true},
+		{"", false},
+		{"alphas", false},
+	}
+
+	for _, test := range tests {
+		result := IsTokenClass(test.input)
+		if result != test.expected {
+			t.Errorf("IsTokenClass(%q) = %v; want %v", test.input, result, test.expected)
+		}
+	}
+}
+
 func TestIsStringASCII(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -106,6 +222,7 @@ func TestConvertMultiByteString(t *testing.T) {
 		{"", ""},
 		{"abc", "abc"},
 		{"世", "?b?b?b"},
+		{"?1\xbc?1", "?1?b?1"},
 	}
 
 	for _, test := range tests {
@@ -0,0 +1,534 @@
+// Package pcfg contains probabilistic context-free grammar structures
+//
+// The package structure is broken into two components:
+//
+// pcfg.go which contains the primary logic
+// pcfg_test.go which contains unit tests
+package pcfg
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jakewnuk/maskcat/pkg/utils"
+)
+
+// GrammarHeader is the first line of a grammar file
+const GrammarHeader = "# maskcat grammar v1"
+
+// grammarDoc documents the grammar file format at the top of each file
+var grammarDoc = []string{
+	GrammarHeader,
+	"#",
+	"# Lines starting with # are comments. Sections start with a line in square",
+	"# brackets and every other line is tab separated as VALUE COUNT PROBABILITY.",
+	"#",
+	"# [structures] lists base structures made of class runs where L is letters,",
+	"# D is digits, S is specials and B is other bytes followed by the run length.",
+	"# [terminals SLOT] lists the text that filled each run such as L6 or D2.",
+	"# Probabilities are counts divided by the total of the section. Values that",
+	"# contain tabs, newlines or start with [ or # are written as $HEX[...].",
+}
+
+// Grammar is a learned grammar of base structures and terminals
+type Grammar struct {
+	Structures map[string]int
+	Terminals  map[string]map[string]int
+}
+
+// NewGrammar creates an empty grammar
+//
+// Returns:
+//
+//	(*Grammar): Empty grammar
+func NewGrammar() *Grammar {
+	return &Grammar{
+		Structures: make(map[string]int),
+		Terminals:  make(map[string]map[string]int),
+	}
+}
+
+// Parse splits a string into its base structure and terminals
+//
+// The string is turned into a mask with utils.MakeMask and runs of the same
+// class become slots such as L6 for six letters. Upper and lower case letters
+// are the same class and bytes outside of ASCII are the B class.
+//
+// Args:
+//
+//	str (string): Input string
+//
+// Returns:
+//
+//	structure (string): Base structure such as L6D2S1
+//	slots ([]string): Slot of each run such as L6
+//	terminals ([]string): Text that filled each run
+func Parse(str string) (string, []string, []string) {
+	mask := utils.MakeMask(str, replacements)
+
+	classes := []byte{}
+	for i := 0; i < len(mask); i++ {
+		if mask[i] != '?' {
+			classes = append(classes, 'B')
+			continue
+		}
+		i++
+		switch mask[i] {
+		case 'u', 'l':
+			classes = append(classes, 'L')
+		case 'd':
+			classes = append(classes, 'D')
+		default:
+			classes = append(classes, 'S')
+		}
+	}
+
+	slots := []string{}
+	terminals := []string{}
+	for start := 0; start < len(classes); {
+		end := start
+		for end < len(classes) && classes[end] == classes[start] {
+			end++
+		}
+		slots = append(slots, fmt.Sprintf("%c%d", classes[start], end-start))
+		terminals = append(terminals, str[start:end])
+		start = end
+	}
+	return strings.Join(slots, ""), slots, terminals
+}
+
+var replacements = utils.ConstructReplacements("ulds")
+
+// Add learns the structure and terminals of a string
+//
+// Args:
+//
+//	str (string): Training string
+func (g *Grammar) Add(str string) {
+	if str == "" {
+		return
+	}
+
+	structure, slots, terminals := Parse(str)
+	g.Structures[structure]++
+	for i, slot := range slots {
+		if g.Terminals[slot] == nil {
+			g.Terminals[slot] = make(map[string]int)
+		}
+		g.Terminals[slot][terminals[i]]++
+	}
+}
+
+// Entry is a value in a grammar section with its count and probability
+type Entry struct {
+	Value       string
+	Count       int
+	Probability float64
+}
+
+// Sorted returns the entries of a section by count descending then value
+//
+// Args:
+//
+//	counts (map[string]int): Section of a grammar
+//
+// Returns:
+//
+//	entries ([]Entry): Sorted entries
+func Sorted(counts map[string]int) []Entry {
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+
+	entries := make([]Entry, 0, len(counts))
+	for value, count := range counts {
+		entries = append(entries, Entry{Value: value, Count: count, Probability: float64(count) / float64(total)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Value < entries[j].Value
+	})
+	return entries
+}
+
+// Write writes the grammar in the documented grammar file format
+//
+// Args:
+//
+//	w (io.Writer): Destination of the grammar
+//
+// Returns:
+//
+//	err (error): Error data
+func (g *Grammar) Write(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	for _, line := range grammarDoc {
+		fmt.Fprintln(out, line)
+	}
+
+	fmt.Fprintln(out, "\n[structures]")
+	for _, e := range Sorted(g.Structures) {
+		fmt.Fprintf(out, "%s\t%d\t%g\n", e.Value, e.Count, e.Probability)
+	}
+
+	slots := make([]string, 0, len(g.Terminals))
+	for slot := range g.Terminals {
+		slots = append(slots, slot)
+	}
+	sort.Strings(slots)
+	for _, slot := range slots {
+		fmt.Fprintf(out, "\n[terminals %s]\n", slot)
+		for _, e := range Sorted(g.Terminals[slot]) {
+			fmt.Fprintf(out, "%s\t%d\t%g\n", encodeValue(e.Value), e.Count, e.Probability)
+		}
+	}
+	return out.Flush()
+}
+
+// ReadGrammar reads a grammar written by Grammar.Write
+//
+// Probabilities in the file are ignored and calculated again from the
+// counts.
+//
+// Args:
+//
+//	r (io.Reader): Source of the grammar
+//
+// Returns:
+//
+//	g (*Grammar): Grammar
+//	err (error): Error data
+func ReadGrammar(r io.Reader) (*Grammar, error) {
+	g := NewGrammar()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var section map[string]int
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if lineNumber == 1 && line != GrammarHeader {
+			return nil, fmt.Errorf("missing grammar header %q", GrammarHeader)
+		}
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case line == "[structures]":
+			section = g.Structures
+			continue
+		case strings.HasPrefix(line, "[terminals ") && strings.HasSuffix(line, "]"):
+			slot := strings.TrimSuffix(strings.TrimPrefix(line, "[terminals "), "]")
+			if g.Terminals[slot] == nil {
+				g.Terminals[slot] = make(map[string]int)
+			}
+			section = g.Terminals[slot]
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if section == nil || len(fields) < 2 {
+			return nil, fmt.Errorf("line %d is not in a section or has too few fields", lineNumber)
+		}
+		value, err := decodeValue(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		count, err := strconv.Atoi(fields[1])
+		if err != nil || count < 1 {
+			return nil, fmt.Errorf("line %d has an invalid count %q", lineNumber, fields[1])
+		}
+		section[value] += count
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if lineNumber == 0 {
+		return nil, fmt.Errorf("missing grammar header %q", GrammarHeader)
+	}
+	return g, nil
+}
+
+// Slot is a list of values that can fill a run sorted by probability
+type Slot struct {
+	Values []string
+	Probs  []float64
+}
+
+// NewSlot creates a slot from sorted entries
+//
+// Args:
+//
+//	entries ([]Entry): Entries sorted by probability such as from Sorted
+//
+// Returns:
+//
+//	(*Slot): Slot of the entries
+func NewSlot(entries []Entry) *Slot {
+	slot := &Slot{Values: make([]string, len(entries)), Probs: make([]float64, len(entries))}
+	for i, e := range entries {
+		slot.Values[i] = e.Value
+		slot.Probs[i] = e.Probability
+	}
+	return slot
+}
+
+// Base is a sequence of slots and the probability of the sequence
+//
+// The same slot can be used more than once in a base.
+type Base struct {
+	Prob  float64
+	Slots []*Slot
+}
+
+// Bases converts the grammar into bases in order of structure probability
+//
+// Structures that use a slot without terminals are skipped.
+//
+// Returns:
+//
+//	bases ([]Base): Bases of the grammar
+func (g *Grammar) Bases() []Base {
+	slots := make(map[string]*Slot)
+	for name, terminals := range g.Terminals {
+		slots[name] = NewSlot(Sorted(terminals))
+	}
+
+	bases := []Base{}
+	for _, e := range Sorted(g.Structures) {
+		base := Base{Prob: e.Probability}
+		for _, name := range SplitStructure(e.Value) {
+			slot, ok := slots[name]
+			if !ok || len(slot.Values) == 0 {
+				base.Slots = nil
+				break
+			}
+			base.Slots = append(base.Slots, slot)
+		}
+		if len(base.Slots) > 0 {
+			bases = append(bases, base)
+		}
+	}
+	return bases
+}
+
+// SplitStructure splits a base structure such as L6D2S1 into its slots
+//
+// Args:
+//
+//	structure (string): Base structure
+//
+// Returns:
+//
+//	slots ([]string): Slots such as L6, D2 and S1
+func SplitStructure(structure string) []string {
+	slots := []string{}
+	for i := 0; i < len(structure); {
+		j := i + 1
+		for j < len(structure) && structure[j] >= '0' && structure[j] <= '9' {
+			j++
+		}
+		slots = append(slots, structure[i:j])
+		i = j
+	}
+	return slots
+}
+
+// Keyspace returns the number of candidates the bases make
+//
+// Args:
+//
+//	bases ([]Base): Bases such as from Grammar.Bases
+//
+// Returns:
+//
+//	keyspace (*big.Int): Number of candidates
+func Keyspace(bases []Base) *big.Int {
+	keyspace := big.NewInt(0)
+	for _, base := range bases {
+		count := big.NewInt(1)
+		for _, slot := range base.Slots {
+			count.Mul(count, big.NewInt(int64(len(slot.Values))))
+		}
+		keyspace.Add(keyspace, count)
+	}
+	return keyspace
+}
+
+// Guess is a candidate made by a queue and its probability
+type Guess struct {
+	Value string
+	Prob  float64
+}
+
+// Queue makes guesses from bases in descending probability
+//
+// The queue uses the pivot algorithm so every combination of values of a
+// base is made exactly once without keeping track of what has been made.
+// Guesses with equal probability are made in a fixed order so the output is
+// always the same for the same bases.
+type Queue struct {
+	bases []Base
+	items queueHeap
+}
+
+// queueItem is a combination of values for a base
+type queueItem struct {
+	base    int
+	indexes []int
+	pivot   int
+	prob    float64
+}
+
+// queueHeap orders items by probability then base then indexes
+type queueHeap []*queueItem
+
+func (h queueHeap) Len() int      { return len(h) }
+func (h queueHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h queueHeap) Less(i, j int) bool {
+	if h[i].prob != h[j].prob {
+		return h[i].prob > h[j].prob
+	}
+	if h[i].base != h[j].base {
+		return h[i].base < h[j].base
+	}
+	for k := range h[i].indexes {
+		if h[i].indexes[k] != h[j].indexes[k] {
+			return h[i].indexes[k] < h[j].indexes[k]
+		}
+	}
+	return false
+}
+func (h *queueHeap) Push(x any) { *h = append(*h, x.(*queueItem)) }
+func (h *queueHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// NewQueue creates a queue from bases
+//
+// Args:
+//
+//	bases ([]Base): Bases to make guesses from
+//
+// Returns:
+//
+//	(*Queue): Queue of guesses
+func NewQueue(bases []Base) *Queue {
+	q := &Queue{}
+	for _, base := range bases {
+		q.Add(base)
+	}
+	return q
+}
+
+// Add adds a base to the queue
+//
+// Bases can be added while guesses are being made so bases can be created
+// lazily. A base must be added before its most probable guess would be made
+// for the guesses to stay in descending probability. Bases added later come
+// after bases added earlier when guesses have equal probability.
+//
+// Args:
+//
+//	base (Base): Base to make guesses from
+//
+// Returns:
+//
+//	None
+func (q *Queue) Add(base Base) {
+	q.bases = append(q.bases, base)
+	if len(base.Slots) == 0 {
+		return
+	}
+	item := &queueItem{base: len(q.bases) - 1, indexes: make([]int, len(base.Slots))}
+	item.prob = q.probability(item)
+	heap.Push(&q.items, item)
+}
+
+// Peek returns the probability of the next guess without making it
+//
+// Returns:
+//
+//	prob (float64): Probability of the next guess
+//	ok (bool): If there was a guess left
+func (q *Queue) Peek() (float64, bool) {
+	if q.items.Len() == 0 {
+		return 0, false
+	}
+	return q.items[0].prob, true
+}
+
+// probability calculates the probability of an item
+func (q *Queue) probability(item *queueItem) float64 {
+	base := q.bases[item.base]
+	prob := base.Prob
+	for i, slot := range base.Slots {
+		prob *= slot.Probs[item.indexes[i]]
+	}
+	return prob
+}
+
+// Next returns the most probable guess that has not been made
+//
+// Returns:
+//
+//	guess (Guess): Next guess
+//	ok (bool): If there was a guess left
+func (q *Queue) Next() (Guess, bool) {
+	if q.items.Len() == 0 {
+		return Guess{}, false
+	}
+
+	item := heap.Pop(&q.items).(*queueItem)
+	base := q.bases[item.base]
+	var value strings.Builder
+	for i, slot := range base.Slots {
+		value.WriteString(slot.Values[item.indexes[i]])
+	}
+
+	// Children only move slots at or after the pivot so each combination
+	// has exactly one parent
+	for i := item.pivot; i < len(base.Slots); i++ {
+		if item.indexes[i]+1 >= len(base.Slots[i].Values) {
+			continue
+		}
+		child := &queueItem{base: item.base, indexes: append([]int(nil), item.indexes...), pivot: i}
+		child.indexes[i]++
+		child.prob = q.probability(child)
+		heap.Push(&q.items, child)
+	}
+
+	return Guess{Value: value.String(), Prob: item.prob}, true
+}
+
+// encodeValue writes values that would break the file format as $HEX[...]
+func encodeValue(value string) string {
+	if value == "" || strings.ContainsAny(value, "\t\r\n") || value[0] == '[' || value[0] == '#' || strings.HasPrefix(value, "$HEX[") {
+		return "$HEX[" + hex.EncodeToString([]byte(value)) + "]"
+	}
+	return value
+}
+
+// decodeValue reads values written by encodeValue
+func decodeValue(value string) (string, error) {
+	if strings.HasPrefix(value, "$HEX[") && strings.HasSuffix(value, "]") {
+		decoded, err := hex.DecodeString(value[5 : len(value)-1])
+		return string(decoded), err
+	}
+	return value, nil
+}
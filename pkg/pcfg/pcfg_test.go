@@ -0,0 +1,127 @@
+package pcfg
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input     string
+		structure string
+		terminals []string
+	}{
+		{"Summer24!", "L6D2S1", []string{"Summer", "24", "!"}},
+		{"password", "L8", []string{"password"}},
+		{"1qaz2wsx", "D1L3D1L3", []string{"1", "qaz", "2", "wsx"}},
+		{"über1", "B2L3D1", []string{"\xc3\xbc", "ber", "1"}},
+		{"", "", []string{}},
+	}
+
+	for _, test := range tests {
+		structure, _, terminals := Parse(test.input)
+		if structure != test.structure || !reflect.DeepEqual(terminals, test.terminals) {
+			t.Errorf("Parse(%q) = (%q, %q); want (%q, %q)", test.input, structure, terminals, test.structure, test.terminals)
+		}
+	}
+}
+
+func TestGrammarRoundTrip(t *testing.T) {
+	g := NewGrammar()
+	for _, line := range []string{"Summer24!", "Winter24!", "password", "[abc]1", "tab\there1"} {
+		g.Add(line)
+	}
+
+	if g.Structures["L6D2S1"] != 2 || g.Terminals["D2"]["24"] != 2 || g.Terminals["L6"]["Winter"] != 1 {
+		t.Fatalf("Grammar.Add counted %v and %v", g.Structures, g.Terminals)
+	}
+
+	var buf bytes.Buffer
+	if err := g.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "[structures]\nL6D2S1\t2\t0.4\n") {
+		t.Errorf("Grammar.Write() = %q; want L6D2S1 first with a count of 2", buf.String())
+	}
+	if !strings.Contains(buf.String(), "$HEX[09]\t1\t") || !strings.Contains(buf.String(), "$HEX[5b]\t1\t") {
+		t.Errorf("Grammar.Write() did not encode unsafe values: %q", buf.String())
+	}
+
+	read, err := ReadGrammar(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, g) {
+		t.Errorf("ReadGrammar() = %v; want %v", read, g)
+	}
+
+	if _, err := ReadGrammar(strings.NewReader("L8\t1\t1\n")); err == nil {
+		t.Errorf("ReadGrammar() did not return an error without a header")
+	}
+}
+
+func TestSorted(t *testing.T) {
+	got := Sorted(map[string]int{"b": 1, "a": 1, "c": 2})
+	want := []Entry{{"c", 2, 0.5}, {"a", 1, 0.25}, {"b", 1, 0.25}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Sorted() = %v; want %v", got, want)
+	}
+}
+
+func TestSplitStructure(t *testing.T) {
+	got := SplitStructure("L6D12S1")
+	want := []string{"L6", "D12", "S1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SplitStructure(%q) = %q; want %q", "L6D12S1", got, want)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	g := NewGrammar()
+	for _, line := range []string{"Summer24!", "Summer24!", "Winter23!", "password", "password", "password"} {
+		g.Add(line)
+	}
+
+	got := []string{}
+	probs := []float64{}
+	q := NewQueue(g.Bases())
+	for guess, ok := q.Next(); ok; guess, ok = q.Next() {
+		got = append(got, guess.Value)
+		probs = append(probs, guess.Prob)
+	}
+
+	want := []string{"password", "Summer24!", "Summer23!", "Winter24!", "Winter23!"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Queue.Next() made %q; want %q", got, want)
+	}
+	for i := 1; i < len(probs); i++ {
+		if probs[i] > probs[i-1] {
+			t.Errorf("Queue.Next() probabilities are not descending: %v", probs)
+		}
+	}
+}
+
+func TestKeyspace(t *testing.T) {
+	one := &Slot{Values: []string{"a"}, Probs: []float64{1}}
+	two := &Slot{Values: []string{"a", "b"}, Probs: []float64{0.5, 0.5}}
+	bases := []Base{{Prob: 0.5, Slots: []*Slot{two, two, one}}, {Prob: 0.5, Slots: []*Slot{two}}}
+	if got := Keyspace(bases).Int64(); got != 6 {
+		t.Errorf("Keyspace() = %d; want 6", got)
+	}
+}
+
+func TestQueueShared(t *testing.T) {
+	slot := &Slot{Values: []string{"a", "b"}, Probs: []float64{0.75, 0.25}}
+	q := NewQueue([]Base{{Prob: 1, Slots: []*Slot{slot, slot}}})
+
+	got := []string{}
+	for guess, ok := q.Next(); ok; guess, ok = q.Next() {
+		got = append(got, guess.Value)
+	}
+	want := []string{"aa", "ab", "ba", "bb"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Queue.Next() made %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,295 @@
+// Package prince contains PRINCE style chains of elements
+//
+// The package structure is broken into two components:
+//
+// prince.go which contains the primary logic
+// prince_test.go which contains unit tests
+package prince
+
+import (
+	"container/heap"
+	"math/big"
+	"sort"
+
+	"github.com/jakewnuk/maskcat/pkg/pcfg"
+)
+
+// DefaultMaxLength is the maximum candidate length used when none is given
+const DefaultMaxLength = 16
+
+// DefaultMaxElements is the default maximum number of elements in a chain
+const DefaultMaxElements = 8
+
+// Elements holds weighted elements that are chained into candidates
+type Elements struct {
+	weights map[string]float64
+}
+
+// NewElements creates an empty set of elements
+//
+// Returns:
+//
+//	(*Elements): Empty elements
+func NewElements() *Elements {
+	return &Elements{weights: make(map[string]float64)}
+}
+
+// Add adds weight to an element
+//
+// Args:
+//
+//	element (string): Element such as a token
+//	weight (float64): Weight to add such as the number of times it was seen
+//
+// Returns:
+//
+//	None
+func (e *Elements) Add(element string, weight float64) {
+	if element == "" || weight <= 0 {
+		return
+	}
+	e.weights[element] += weight
+}
+
+// Len returns the number of distinct elements
+//
+// Returns:
+//
+//	(int): Number of elements
+func (e *Elements) Len() int {
+	return len(e.weights)
+}
+
+// Keyspace returns the number of candidates the chains make
+//
+// The keyspace is counted over total lengths rather than over chains since
+// the number of chains grows too quickly to list them.
+//
+// Args:
+//
+//	minLen (int): Minimum total length of a chain
+//	maxLen (int): Maximum total length of a chain
+//	maxElements (int): Maximum number of elements in a chain
+//
+// Returns:
+//
+//	keyspace (*big.Int): Number of candidates
+func (e *Elements) Keyspace(minLen int, maxLen int, maxElements int) *big.Int {
+	counts := make(map[int]int64)
+	for element := range e.weights {
+		counts[len(element)]++
+	}
+
+	// ways[n] is the number of candidates of length n made with the number
+	// of elements of the current round
+	ways := make([]*big.Int, maxLen+1)
+	for n := range ways {
+		ways[n] = big.NewInt(0)
+	}
+	ways[0].SetInt64(1)
+
+	keyspace := big.NewInt(0)
+	product := new(big.Int)
+	for round := 0; round < maxElements; round++ {
+		next := make([]*big.Int, maxLen+1)
+		for n := range next {
+			next[n] = big.NewInt(0)
+		}
+		for n, count := range ways {
+			if count.Sign() == 0 {
+				continue
+			}
+			for length, elements := range counts {
+				if n+length <= maxLen {
+					next[n+length].Add(next[n+length], product.Mul(count, big.NewInt(elements)))
+				}
+			}
+		}
+
+		ways = next
+		for n := minLen; n <= maxLen; n++ {
+			keyspace.Add(keyspace, ways[n])
+		}
+	}
+	return keyspace
+}
+
+// Queue makes chains in descending probability
+//
+// Elements are grouped into a slot for each length and every chain of
+// lengths is a pcfg.Base. The probability of a chain is the product of the
+// probability of each of its lengths so a candidate's probability is the
+// product of the probability of each element. Chains are only added to the
+// guess queue once their most probable candidate is due so memory grows with
+// the output rather than with the number of chains.
+type Queue struct {
+	guesses *pcfg.Queue
+	chains  chainHeap
+	lengths []chainLength
+	minLen  int
+	maxLen  int
+	maxElem int
+}
+
+// chainLength is the slot of one element length
+type chainLength struct {
+	length int
+	prob   float64
+	top    float64
+	slot   *pcfg.Slot
+}
+
+// chain is a sequence of lengths given as indexes into Queue.lengths
+type chain struct {
+	indexes []int
+	length  int
+	top     float64
+}
+
+// chainHeap orders chains by their most probable candidate then by lengths
+type chainHeap struct {
+	items   []*chain
+	lengths []chainLength
+}
+
+func (h chainHeap) Len() int      { return len(h.items) }
+func (h chainHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h chainHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if a.top != b.top {
+		return a.top > b.top
+	}
+	for k := 0; k < len(a.indexes) && k < len(b.indexes); k++ {
+		if a.indexes[k] != b.indexes[k] {
+			return h.lengths[a.indexes[k]].length < h.lengths[b.indexes[k]].length
+		}
+	}
+	return len(a.indexes) < len(b.indexes)
+}
+func (h *chainHeap) Push(x any) { h.items = append(h.items, x.(*chain)) }
+func (h *chainHeap) Pop() any {
+	old := h.items
+	item := old[len(old)-1]
+	h.items = old[:len(old)-1]
+	return item
+}
+
+// Queue creates a queue of every chain of elements within the bounds
+//
+// Args:
+//
+//	minLen (int): Minimum total length of a chain
+//	maxLen (int): Maximum total length of a chain
+//	maxElements (int): Maximum number of elements in a chain
+//
+// Returns:
+//
+//	(*Queue): Queue of candidates
+func (e *Elements) Queue(minLen int, maxLen int, maxElements int) *Queue {
+	total := 0.0
+	byLength := make(map[int][]string)
+	lengthWeights := make(map[int]float64)
+	for element, weight := range e.weights {
+		byLength[len(element)] = append(byLength[len(element)], element)
+		lengthWeights[len(element)] += weight
+		total += weight
+	}
+
+	q := &Queue{guesses: pcfg.NewQueue(nil), minLen: minLen, maxLen: maxLen, maxElem: maxElements}
+	for length, elements := range byLength {
+		slot := e.slot(elements, lengthWeights[length])
+		prob := lengthWeights[length] / total
+		q.lengths = append(q.lengths, chainLength{length: length, prob: prob, top: prob * slot.Probs[0], slot: slot})
+	}
+
+	// Lengths are sorted by their most probable element so the next length
+	// of a chain is never more probable than the one it replaces
+	sort.Slice(q.lengths, func(i, j int) bool {
+		if q.lengths[i].top != q.lengths[j].top {
+			return q.lengths[i].top > q.lengths[j].top
+		}
+		return q.lengths[i].length < q.lengths[j].length
+	})
+	q.chains.lengths = q.lengths
+	q.expand(&chain{top: 1})
+	return q
+}
+
+// Next returns the most probable candidate that has not been made
+//
+// Returns:
+//
+//	guess (pcfg.Guess): Next candidate
+//	ok (bool): If there was a candidate left
+func (q *Queue) Next() (pcfg.Guess, bool) {
+	for q.chains.Len() > 0 {
+		prob, ok := q.guesses.Peek()
+		if ok && prob > q.chains.items[0].top {
+			break
+		}
+
+		c := heap.Pop(&q.chains).(*chain)
+		if c.length >= q.minLen {
+			base := pcfg.Base{Prob: 1, Slots: make([]*pcfg.Slot, len(c.indexes))}
+			for i, index := range c.indexes {
+				base.Prob *= q.lengths[index].prob
+				base.Slots[i] = q.lengths[index].slot
+			}
+			q.guesses.Add(base)
+		}
+		q.expand(c)
+	}
+	return q.guesses.Next()
+}
+
+// expand adds the chains that follow a chain
+//
+// Each chain has one parent so every chain is made once. The first child
+// appends the most probable length that fits and the next sibling replaces
+// the last length with the next length that fits.
+func (q *Queue) expand(c *chain) {
+	if len(c.indexes) < q.maxElem {
+		q.push(c.indexes, c.length, 0)
+	}
+	if n := len(c.indexes); n > 0 {
+		q.push(c.indexes[:n-1], c.length-q.lengths[c.indexes[n-1]].length, c.indexes[n-1]+1)
+	}
+}
+
+// push adds the chain made by appending the first length from start that
+// fits to a prefix
+func (q *Queue) push(prefix []int, length int, start int) {
+	for i := start; i < len(q.lengths); i++ {
+		if length+q.lengths[i].length > q.maxLen {
+			continue
+		}
+
+		// The most probable candidate is calculated the same way as
+		// pcfg.Queue so equal probabilities compare equal
+		c := &chain{indexes: append(append(make([]int, 0, len(prefix)+1), prefix...), i), length: length + q.lengths[i].length, top: 1}
+		for _, index := range c.indexes {
+			c.top *= q.lengths[index].prob
+		}
+		for _, index := range c.indexes {
+			c.top *= q.lengths[index].slot.Probs[0]
+		}
+		heap.Push(&q.chains, c)
+		return
+	}
+}
+
+// slot creates a slot of elements of one length sorted by weight
+func (e *Elements) slot(values []string, total float64) *pcfg.Slot {
+	sort.Slice(values, func(i, j int) bool {
+		if e.weights[values[i]] != e.weights[values[j]] {
+			return e.weights[values[i]] > e.weights[values[j]]
+		}
+		return values[i] < values[j]
+	})
+
+	slot := &pcfg.Slot{Values: values, Probs: make([]float64, len(values))}
+	for i, value := range values {
+		slot.Probs[i] = e.weights[value] / total
+	}
+	return slot
+}
@@ -0,0 +1,88 @@
+package prince
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQueue(t *testing.T) {
+	e := NewElements()
+	e.Add("a", 3)
+	e.Add("b", 1)
+	e.Add("cd", 4)
+
+	tests := []struct {
+		minLen      int
+		maxLen      int
+		maxElements int
+		expected    int
+	}{
+		{1, 1, 8, 2},
+		{1, 2, 8, 2 + 4 + 1},
+		{2, 2, 1, 1},
+		{3, 4, 8, 8 + 2 + 2 + 16 + 4 + 4 + 4 + 1},
+	}
+
+	for _, test := range tests {
+		if got := e.Keyspace(test.minLen, test.maxLen, test.maxElements).Int64(); got != int64(test.expected) {
+			t.Errorf("Keyspace(%d, %d, %d) = %d; want %d", test.minLen, test.maxLen, test.maxElements, got, test.expected)
+		}
+
+		count := 0
+		seen := make(map[string]bool)
+		prob := 1.0
+		q := e.Queue(test.minLen, test.maxLen, test.maxElements)
+		for guess, ok := q.Next(); ok; guess, ok = q.Next() {
+			if guess.Prob > prob {
+				t.Errorf("Queue(%d, %d, %d) made %q after a less probable guess", test.minLen, test.maxLen, test.maxElements, guess.Value)
+			}
+			prob = guess.Prob
+			seen[guess.Value] = true
+			count++
+		}
+		if count != test.expected || len(seen) != test.expected {
+			t.Errorf("Queue(%d, %d, %d) made %d guesses with %d distinct; want %d", test.minLen, test.maxLen, test.maxElements, count, len(seen), test.expected)
+		}
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	e := NewElements()
+	e.Add("pass", 3)
+	e.Add("word", 1)
+	e.Add("1", 4)
+
+	got := []string{}
+	q := e.Queue(5, 5, 2)
+	for guess, ok := q.Next(); ok; guess, ok = q.Next() {
+		got = append(got, guess.Value)
+	}
+
+	want := []string{"1pass", "pass1", "1word", "word1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Queue.Next() made %q; want %q", got, want)
+	}
+}
+
+func TestKeyspaceLarge(t *testing.T) {
+	e := NewElements()
+	for length := 1; length <= 40; length++ {
+		e.Add(strings.Repeat("a", length), 1)
+	}
+
+	// Every composition of the lengths 1 to 40 into at most 8 parts
+	if got := e.Keyspace(1, 40, 8).String(); got != "100146723" {
+		t.Errorf("Keyspace(1, 40, 8) = %s; want 100146723", got)
+	}
+
+	q := e.Queue(1, 40, 8)
+	for i := 0; i < 1000; i++ {
+		if _, ok := q.Next(); !ok {
+			t.Fatalf("Queue(1, 40, 8) stopped after %d guesses", i)
+		}
+	}
+	if q.chains.Len() > 10000 {
+		t.Errorf("Queue(1, 40, 8) holds %d chains after 1000 guesses", q.chains.Len())
+	}
+}
@@ -0,0 +1,168 @@
+// Package score contains models that score candidates by log probability
+//
+// The package structure is broken into two components:
+//
+// score.go which contains the primary logic
+// score_test.go which contains unit tests
+package score
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/jakewnuk/maskcat/pkg/pcfg"
+	"github.com/jakewnuk/maskcat/pkg/utils"
+)
+
+// Model learns from training strings and scores candidates
+//
+// Scores are natural log probabilities so higher scores are more likely.
+type Model interface {
+	Add(str string)
+	Score(str string) float64
+}
+
+// counts holds how often each value was seen and the total
+type counts struct {
+	values map[string]int
+	total  int
+}
+
+// add adds a value to the counts
+func (c *counts) add(value string) {
+	if c.values == nil {
+		c.values = make(map[string]int)
+	}
+	c.values[value]++
+	c.total++
+}
+
+// logProb returns the smoothed log probability of a value
+//
+// One is added to every count and one extra value is counted for anything
+// unseen. The probability of that extra value is shared by every value that
+// could have been seen so keyspace is the log of the number of them. Unseen
+// values lower a score rather than ruling it out.
+func (c *counts) logProb(value string, keyspace float64) float64 {
+	count, ok := c.values[value]
+	prob := math.Log(float64(count+1) / float64(c.total+len(c.values)+1))
+	if !ok {
+		prob -= keyspace
+	}
+	return prob
+}
+
+// classSizes are the number of characters in each class of a slot
+var classSizes = map[byte]float64{'L': 52, 'D': 10, 'S': 33, 'B': 128}
+
+// slotKeyspace returns the log of the number of values that fit a slot
+func slotKeyspace(slot string) float64 {
+	length, _ := strconv.Atoi(slot[1:])
+	return float64(length) * math.Log(classSizes[slot[0]])
+}
+
+// MaskModel scores candidates by the frequency of their mask multiplied by
+// the frequency of the text in each of their runs
+//
+// Masks are made with utils.MakeMask so case is part of the mask. Runs are
+// the slots of pcfg.Parse such as L6 for six letters and the text in a run is
+// only compared to text seen in runs of the same slot.
+type MaskModel struct {
+	masks  counts
+	tokens map[string]*counts
+}
+
+// NewMaskModel creates an empty mask model
+//
+// Returns:
+//
+//	(*MaskModel): Empty model
+func NewMaskModel() *MaskModel {
+	return &MaskModel{tokens: make(map[string]*counts)}
+}
+
+var replacements = utils.ConstructReplacements("ulds")
+
+// Add learns the mask and run text of a training string
+//
+// Args:
+//
+//	str (string): Training string
+//
+// Returns:
+//
+//	None
+func (m *MaskModel) Add(str string) {
+	if str == "" {
+		return
+	}
+
+	m.masks.add(utils.MakeMask(str, replacements))
+	_, slots, terminals := pcfg.Parse(str)
+	for i, slot := range slots {
+		if m.tokens[slot] == nil {
+			m.tokens[slot] = &counts{}
+		}
+		m.tokens[slot].add(terminals[i])
+	}
+}
+
+// Score returns the log probability of a candidate
+//
+// Args:
+//
+//	str (string): Candidate
+//
+// Returns:
+//
+//	score (float64): Natural log probability
+func (m *MaskModel) Score(str string) float64 {
+	// Each byte of an unseen mask could have been one of four classes
+	score := m.masks.logProb(utils.MakeMask(str, replacements), float64(len(str))*math.Log(4))
+	_, slots, terminals := pcfg.Parse(str)
+	for i, slot := range slots {
+		tokens := m.tokens[slot]
+		if tokens == nil {
+			tokens = &counts{}
+		}
+		score += tokens.logProb(terminals[i], slotKeyspace(slot))
+	}
+	return score
+}
+
+// Format formats a score to four decimal places
+//
+// Args:
+//
+//	score (float64): Score
+//
+// Returns:
+//
+//	(string): Formatted score
+func Format(score float64) string {
+	return fmt.Sprintf("%.4f", score)
+}
+
+// KeyLength is the length of the keys made by Key
+const KeyLength = 16
+
+// Key returns a fixed length key that sorts in byte order from the highest
+// score to the lowest
+//
+// Args:
+//
+//	score (float64): Score
+//
+// Returns:
+//
+//	(string): Hex key of KeyLength characters
+func Key(score float64) string {
+	bits := math.Float64bits(score)
+	if score >= 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return fmt.Sprintf("%016x", ^bits)
+}
@@ -0,0 +1,58 @@
+package score
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/jakewnuk/maskcat/pkg/markov"
+)
+
+func TestMaskModel(t *testing.T) {
+	m := NewMaskModel()
+	for _, line := range []string{"Summer24!", "Winter24!", "Summer23!", "password", "password1"} {
+		m.Add(line)
+	}
+
+	tests := []struct {
+		likely   string
+		unlikely string
+	}{
+		{"Summer24!", "Summer99!"},
+		{"Summer24!", "summer24!"},
+		{"Winter24!", "Qzxjkv24!"},
+		{"password", "p4ssw0rd"},
+		{"Summer24!", "zzzz"},
+		{"zzzzzz", "zzzzzzzzzzzz"},
+	}
+
+	for _, test := range tests {
+		if m.Score(test.likely) <= m.Score(test.unlikely) {
+			t.Errorf("Score(%q) = %f is not above Score(%q) = %f", test.likely, m.Score(test.likely), test.unlikely, m.Score(test.unlikely))
+		}
+	}
+}
+
+func TestModels(t *testing.T) {
+	var _ Model = NewMaskModel()
+	var _ Model = markov.NewStats()
+}
+
+func TestKey(t *testing.T) {
+	scores := []float64{-1.5, 0, 2, -100, 3.25, -0.001}
+	keys := []string{}
+	for _, score := range scores {
+		key := Key(score)
+		if len(key) != KeyLength {
+			t.Errorf("Key(%f) = %q is not %d characters", score, key, KeyLength)
+		}
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	sort.Sort(sort.Reverse(sort.Float64Slice(scores)))
+	for i, score := range scores {
+		if keys[i] != Key(score) {
+			t.Errorf("Key() sorted %f at position %d", score, i)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// Package semantic contains recognition of years, dates, months and seasons
+//
+// The package structure is broken into two components:
+//
+// semantic.go which contains the primary logic
+// semantic_test.go which contains unit tests
+package semantic
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MinYear and MaxYear bound the years that are recognized
+const (
+	MinYear = 1950
+	MaxYear = 2029
+)
+
+// Kinds of semantic tokens
+const (
+	KindYear   = "year"
+	KindDate   = "date"
+	KindMonth  = "month"
+	KindSeason = "season"
+)
+
+// HintCharsets are the custom charsets used by hint masks
+//
+//	?1 is the first digit of a year
+//	?2 is the second digit of a year
+//	?3 is the first digit of a day
+//	?4 is the first digit of a month
+const HintCharsets = "12,09,0123,01"
+
+// Match is a semantic token found in a string
+//
+// Start and End are byte offsets into the string. Format describes the layout
+// of the token such as "DDMMYYYY" for dates, "Month" and "Mon" for full and
+// short month names and "Season" for seasons.
+type Match struct {
+	Kind   string
+	Format string
+	Token  string
+	Start  int
+	End    int
+}
+
+// dateFormats are the date layouts recognized for each digit run length in
+// order of preference
+var dateFormats = map[int][]string{
+	8: {"YYYYMMDD", "DDMMYYYY", "MMDDYYYY"},
+	6: {"DDMMYY", "MMDDYY"},
+}
+
+var months = []string{"january", "february", "march", "april", "may", "june", "july", "august", "september", "october", "november", "december"}
+
+var seasons = []string{"spring", "summer", "autumn", "fall", "winter"}
+
+var tokenRegex = regexp.MustCompile(`\d+|[A-Z]?[a-z]+|[A-Z]+`)
+
+// Find finds the semantic tokens in a string
+//
+// Digit runs are only matched as a whole so years inside longer numbers are
+// not found. Words are matched case insensitively and split on camel case.
+//
+// Args:
+//
+//	str (string): Input string
+//
+// Returns:
+//
+//	found ([]Match): Semantic tokens in order of appearance
+func Find(str string) []Match {
+	found := []Match{}
+	for _, loc := range tokenRegex.FindAllStringIndex(str, -1) {
+		token := str[loc[0]:loc[1]]
+		kind, format := classify(token)
+		if kind != "" {
+			found = append(found, Match{Kind: kind, Format: format, Token: token, Start: loc[0], End: loc[1]})
+		}
+	}
+	return found
+}
+
+// classify returns the kind and format of a token or empty strings
+func classify(token string) (string, string) {
+	if token[0] >= '0' && token[0] <= '9' {
+		if len(token) == 4 && validYear(token) {
+			return KindYear, "YYYY"
+		}
+		for _, format := range dateFormats[len(token)] {
+			if validDate(token, format) {
+				return KindDate, format
+			}
+		}
+		return "", ""
+	}
+
+	lower := strings.ToLower(token)
+	for _, month := range months {
+		if lower == month {
+			return KindMonth, "Month"
+		}
+		if lower == month[:3] {
+			return KindMonth, "Mon"
+		}
+	}
+	for _, season := range seasons {
+		if lower == season {
+			return KindSeason, "Season"
+		}
+	}
+	return "", ""
+}
+
+// validYear tests if four digits are a year within the recognized range
+func validYear(digits string) bool {
+	year := number(digits)
+	return year >= MinYear && year <= MaxYear
+}
+
+// validDate tests if digits are a real date in the given format
+func validDate(digits string, format string) bool {
+	year, month, day := 0, 0, 0
+	for i := 0; i < len(format); {
+		width := strings.LastIndexByte(format, format[i]) - i + 1
+		value := number(digits[i : i+width])
+		switch format[i] {
+		case 'Y':
+			year = value
+			if width == 2 {
+				year = expandYear(value)
+			}
+		case 'M':
+			month = value
+		case 'D':
+			day = value
+		}
+		i += width
+	}
+
+	if year < MinYear || year > MaxYear || month < 1 || month > 12 || day < 1 {
+		return false
+	}
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC).Day() == day
+}
+
+// expandYear converts a two digit year into a year within the recognized
+// range
+func expandYear(yy int) int {
+	if 1900+yy >= MinYear {
+		return 1900 + yy
+	}
+	return 2000 + yy
+}
+
+// number converts digits into an integer
+func number(digits string) int {
+	n := 0
+	for i := 0; i < len(digits); i++ {
+		n = n*10 + int(digits[i]-'0')
+	}
+	return n
+}
+
+// Hint returns a mask that narrows the digits of a year or date using the
+// custom charsets in HintCharsets
+//
+// Args:
+//
+//	m (Match): Semantic token
+//
+// Returns:
+//
+//	(string): Hint mask or an empty string for months and seasons
+func Hint(m Match) string {
+	if m.Kind != KindYear && m.Kind != KindDate {
+		return ""
+	}
+
+	var hint strings.Builder
+	for i := 0; i < len(m.Format); {
+		width := strings.LastIndexByte(m.Format, m.Format[i]) - i + 1
+		switch {
+		case m.Format[i] == 'Y' && width == 4:
+			hint.WriteString("?1?2?d?d")
+		case m.Format[i] == 'Y':
+			hint.WriteString("?d?d")
+		case m.Format[i] == 'M':
+			hint.WriteString("?4?d")
+		case m.Format[i] == 'D':
+			hint.WriteString("?3?d")
+		}
+		i += width
+	}
+	return hint.String()
+}
+
+// Values returns every value of the same kind and format as a token
+//
+// Months and seasons keep the case of the token so "Summer" gives "Spring"
+// and "SUMMER" gives "SPRING". Dates cover every day from MinYear to MaxYear.
+//
+// Args:
+//
+//	m (Match): Semantic token
+//
+// Returns:
+//
+//	values ([]string): Values in order
+func Values(m Match) []string {
+	values := []string{}
+	switch m.Kind {
+	case KindYear:
+		for year := MinYear; year <= MaxYear; year++ {
+			values = append(values, formatDate(m.Format, year, 1, 1))
+		}
+	case KindDate:
+		day := time.Date(MinYear, 1, 1, 0, 0, 0, 0, time.UTC)
+		for day.Year() <= MaxYear {
+			values = append(values, formatDate(m.Format, day.Year(), int(day.Month()), day.Day()))
+			day = day.AddDate(0, 0, 1)
+		}
+	case KindMonth:
+		for _, month := range months {
+			if m.Format == "Mon" {
+				month = month[:3]
+			}
+			values = append(values, matchCase(month, m.Token))
+		}
+	case KindSeason:
+		for _, season := range seasons {
+			values = append(values, matchCase(season, m.Token))
+		}
+	}
+	return values
+}
+
+// formatDate writes a date in the given format
+func formatDate(format string, year int, month int, day int) string {
+	var result strings.Builder
+	for i := 0; i < len(format); {
+		width := strings.LastIndexByte(format, format[i]) - i + 1
+		value := 0
+		switch format[i] {
+		case 'Y':
+			value = year
+		case 'M':
+			value = month
+		case 'D':
+			value = day
+		}
+
+		digits := []byte{}
+		for j := 0; j < width; j++ {
+			digits = append([]byte{byte('0' + value%10)}, digits...)
+			value /= 10
+		}
+		result.Write(digits)
+		i += width
+	}
+	return result.String()
+}
+
+// matchCase writes a lower case word in the case of an example token
+func matchCase(word string, example string) string {
+	switch {
+	case example == strings.ToUpper(example):
+		return strings.ToUpper(word)
+	case example[0] >= 'A' && example[0] <= 'Z':
+		return strings.ToUpper(word[:1]) + word[1:]
+	}
+	return word
+}
@@ -0,0 +1,85 @@
+package semantic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []Match
+	}{
+		{"Summer2023!", []Match{
+			{Kind: KindSeason, Format: "Season", Token: "Summer", Start: 0, End: 6},
+			{Kind: KindYear, Format: "YYYY", Token: "2023", Start: 6, End: 10},
+		}},
+		{"25121990", []Match{{Kind: KindDate, Format: "DDMMYYYY", Token: "25121990", Start: 0, End: 8}}},
+		{"19901225", []Match{{Kind: KindDate, Format: "YYYYMMDD", Token: "19901225", Start: 0, End: 8}}},
+		{"12251990", []Match{{Kind: KindDate, Format: "MMDDYYYY", Token: "12251990", Start: 0, End: 8}}},
+		{"bob311299", []Match{{Kind: KindDate, Format: "DDMMYY", Token: "311299", Start: 3, End: 9}}},
+		{"JanDEC", []Match{
+			{Kind: KindMonth, Format: "Mon", Token: "Jan", Start: 0, End: 3},
+			{Kind: KindMonth, Format: "Mon", Token: "DEC", Start: 3, End: 6},
+		}},
+		{"30021990", []Match{}},
+		{"pass1234", []Match{}},
+		{"120231", []Match{}},
+		{"1949", []Match{}},
+		{"Decimal", []Match{}},
+	}
+
+	for _, test := range tests {
+		got := Find(test.input)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Find(%q) = %+v; want %+v", test.input, got, test.expected)
+		}
+	}
+}
+
+func TestHint(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"2023", "?1?2?d?d"},
+		{"25121990", "?3?d?4?d?1?2?d?d"},
+		{"122599", "?4?d?3?d?d?d"},
+		{"March", ""},
+	}
+
+	for _, test := range tests {
+		matches := Find(test.input)
+		if got := Hint(matches[0]); got != test.expected {
+			t.Errorf("Hint(%q) = %q; want %q", test.input, got, test.expected)
+		}
+	}
+}
+
+func TestValues(t *testing.T) {
+	year := Values(Find("2023")[0])
+	if len(year) != MaxYear-MinYear+1 || year[0] != "1950" || year[len(year)-1] != "2029" {
+		t.Errorf("Values(2023) = %d values from %q to %q", len(year), year[0], year[len(year)-1])
+	}
+
+	date := Values(Find("311299")[0])
+	if len(date) != 29220 || date[0] != "010150" || date[len(date)-1] != "311229" {
+		t.Errorf("Values(311299) = %d values from %q to %q", len(date), date[0], date[len(date)-1])
+	}
+
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"SUMMER", []string{"SPRING", "SUMMER", "AUTUMN", "FALL", "WINTER"}},
+		{"Fall", []string{"Spring", "Summer", "Autumn", "Fall", "Winter"}},
+		{"jan", []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	}
+
+	for _, test := range tests {
+		got := Values(Find(test.input)[0])
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Values(%q) = %q; want %q", test.input, got, test.expected)
+		}
+	}
+}
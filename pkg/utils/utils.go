@@ -8,12 +8,19 @@ package utils
 
 import (
 	"encoding/hex"
+	"fmt"
+	"math/rand"
 	"os"
 	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 
+	"github.com/jakewnuk/maskcat/pkg/masks"
 	"github.com/jakewnuk/maskcat/pkg/models"
+	"github.com/jakewnuk/maskcat/pkg/semantic"
 )
 
 // ConstructReplacements create an array mapping which characters to replace
@@ -82,6 +89,722 @@ func MakeMask(str string, replacements []string) string {
 	return strings.NewReplacer(replacements...).Replace(str)
 }
 
+// MakeHexMask performs substitution to make masks with hex runs as ?h or ?H
+//
+// A hex run is a run of at least minLen hex characters that contains both
+// digits and letters of a single case. Lower case runs become ?h and upper
+// case runs become ?H. The rest of the string is replaced with MakeMask.
+//
+// Args:
+//
+//	str (string): String to turn into a mask
+//	replacements ([]string): Map of which characters to replace
+//	minLen (int): Minimum length of a hex run
+//
+// Returns:
+//
+//	(string): Replaced string as a mask
+func MakeHexMask(str string, replacements []string, minLen int) string {
+	var result strings.Builder
+	start := 0
+	for i := 0; i < len(str); {
+		j := i
+		for j < len(str) && strings.IndexByte("0123456789abcdefABCDEF", str[j]) != -1 {
+			j++
+		}
+		if j == i {
+			i++
+			continue
+		}
+
+		if class := hexRunClass(str[i:j]); class != "" && j-i >= minLen {
+			result.WriteString(MakeMask(str[start:i], replacements))
+			result.WriteString(strings.Repeat(class, j-i))
+			start = j
+		}
+		i = j
+	}
+	result.WriteString(MakeMask(str[start:], replacements))
+	return result.String()
+}
+
+// hexRunClass returns the mask class of a run of hex characters or an empty
+// string if the run does not look like hex
+func hexRunClass(run string) string {
+	hasDigit := strings.ContainsAny(run, "0123456789")
+	hasLower := strings.ContainsAny(run, "abcdef")
+	hasUpper := strings.ContainsAny(run, "ABCDEF")
+	switch {
+	case hasDigit && hasLower && !hasUpper:
+		return "?h"
+	case hasDigit && hasUpper && !hasLower:
+		return "?H"
+	}
+	return ""
+}
+
+// MakeSemanticMask performs substitution to make masks with hints for years
+// and dates
+//
+// Years and dates found by semantic.Find are replaced with hint masks that
+// use the custom charsets in semantic.HintCharsets and the result is returned
+// as a .hcmask line. The rest of the string is replaced with MakeMask and
+// strings without years or dates are returned as a plain mask.
+//
+// Args:
+//
+//	str (string): String to turn into a mask
+//	replacements ([]string): Map of which characters to replace
+//
+// Returns:
+//
+//	(string): Mask or .hcmask line
+func MakeSemanticMask(str string, replacements []string) string {
+	var result strings.Builder
+	hinted := false
+	start := 0
+	for _, m := range semantic.Find(str) {
+		hint := semantic.Hint(m)
+		if hint == "" {
+			continue
+		}
+		result.WriteString(MakeMask(str[start:m.Start], replacements))
+		result.WriteString(hint)
+		start = m.End
+		hinted = true
+	}
+	result.WriteString(MakeMask(str[start:], replacements))
+
+	if !hinted {
+		return result.String()
+	}
+	return semantic.HintCharsets + "," + result.String()
+}
+
+// ExpandSemanticMask performs substitution to make masks where years, dates,
+// months and seasons are replaced with every realistic value
+//
+// Each semantic token found by semantic.Find is replaced with the values
+// from semantic.Values as literal text so only realistic candidates are
+// produced. Values closest to the token are used first so Summer is followed
+// by Spring and Autumn and a date by the days around it. Strings with several
+// semantic tokens produce every combination up to the limit.
+//
+// Args:
+//
+//	str (string): String to turn into masks
+//	replacements ([]string): Map of which characters to replace
+//	limit (int): Maximum number of masks to make (0 makes every mask)
+//	fn (func(string) bool): Called with each mask and returns false to stop
+func ExpandSemanticMask(str string, replacements []string, limit int, fn func(string) bool) {
+	matches := semantic.Find(str)
+	if len(matches) == 0 {
+		fn(MakeMask(str, replacements))
+		return
+	}
+
+	values := make([][]string, len(matches))
+	for i, m := range matches {
+		values[i] = nearestFirst(semantic.Values(m), m.Token)
+	}
+
+	made := 0
+	var expand func(i int, start int, prefix string) bool
+	expand = func(i int, start int, prefix string) bool {
+		if i == len(matches) {
+			made++
+			return fn(prefix+MakeMask(str[start:], replacements)) && made != limit
+		}
+
+		prefix += MakeMask(str[start:matches[i].Start], replacements)
+		for _, value := range values[i] {
+			if !expand(i+1, matches[i].End, prefix+value) {
+				return false
+			}
+		}
+		return true
+	}
+	expand(0, 0, "")
+}
+
+// nearestFirst orders values outward from the position of a token
+func nearestFirst(values []string, token string) []string {
+	at := -1
+	for i, value := range values {
+		if value == token {
+			at = i
+			break
+		}
+	}
+	if at == -1 {
+		return values
+	}
+
+	ordered := []string{values[at]}
+	for d := 1; len(ordered) < len(values); d++ {
+		if at-d >= 0 {
+			ordered = append(ordered, values[at-d])
+		}
+		if at+d < len(values) {
+			ordered = append(ordered, values[at+d])
+		}
+	}
+	return ordered
+}
+
+// CollapseMask replaces all printable mask characters with ?a
+//
+// Positions using ?l, ?u, ?d, ?s, ?h or ?H become ?a while literal text,
+// ?b and custom charsets are kept.
+//
+// Args:
+//
+//	mask (string): Mask to collapse
+//
+// Returns:
+//
+//	(string): Collapsed mask
+func CollapseMask(mask string) string {
+	var result strings.Builder
+	for i := 0; i < len(mask); i++ {
+		if mask[i] != '?' || i+1 >= len(mask) {
+			result.WriteByte(mask[i])
+			continue
+		}
+
+		if strings.IndexByte("ludshH", mask[i+1]) != -1 {
+			result.WriteString("?a")
+		} else {
+			result.WriteString(mask[i : i+2])
+		}
+		i++
+	}
+	return result.String()
+}
+
+// MakeCustomMask replaces non-ASCII bytes in a mask with custom charsets
+//
+// Each non-ASCII byte left in the mask is replaced by the first custom
+// charset from ?1 to ?4 that contains it. Bytes that are not in a custom
+// charset are left for models.EnsureValidMask to convert into ?b.
+//
+// Args:
+//
+//	mask (string): Mask made by MakeMask
+//	custom (map[byte]masks.Charset): Custom charsets keyed by '1' to '4'
+//
+// Returns:
+//
+//	(string): Mask using custom charsets
+func MakeCustomMask(mask string, custom map[byte]masks.Charset) string {
+	if len(custom) == 0 {
+		return mask
+	}
+
+	var result strings.Builder
+	for i := 0; i < len(mask); i++ {
+		b := mask[i]
+		if b == '?' && i+1 < len(mask) {
+			result.WriteString(mask[i : i+2])
+			i++
+			continue
+		}
+
+		replaced := false
+		for class := byte('1'); b > 127 && class <= '4'; class++ {
+			if c, ok := custom[class]; ok && c.Has(b) {
+				result.WriteString("?" + string(class))
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			result.WriteByte(b)
+		}
+	}
+	return result.String()
+}
+
+// MaskToRegex converts a mask into an anchored regular expression
+//
+// Masks can contain built-in charsets, custom charsets and literal text. The
+// expression uses syntax shared by Go and PCRE. Masks match bytes so ?b is
+// converted to (?s:.) which matches any byte in PCRE but any rune in Go.
+// Other charsets with bytes above \x7f are classes that only match bytes in
+// PCRE and match Latin-1 runes in Go.
+//
+// Args:
+//
+//	mask (string): Mask to convert
+//	custom (map[byte]masks.Charset): Custom charsets keyed by '1' to '4'
+//
+// Returns:
+//
+//	(string): Anchored regular expression
+//	err (error): Error data
+func MaskToRegex(mask string, custom map[byte]masks.Charset) (string, error) {
+	positions, err := masks.ParseMask(mask, custom)
+	if err != nil {
+		return "", err
+	}
+
+	var result strings.Builder
+	result.WriteString("^")
+	for i := 0; i < len(positions); {
+		// Literal runs are quoted together so multibyte text stays intact
+		if positions[i].Size() == 1 {
+			literal := []byte{}
+			for ; i < len(positions) && positions[i].Size() == 1; i++ {
+				literal = append(literal, positions[i].Bytes()[0])
+			}
+			if utf8.Valid(literal) {
+				result.WriteString(regexp.QuoteMeta(string(literal)))
+			} else {
+				for _, b := range literal {
+					result.WriteString(regexByte(b))
+				}
+			}
+			continue
+		}
+
+		count := 1
+		for i+count < len(positions) && positions[i+count] == positions[i] {
+			count++
+		}
+		if positions[i] == masks.Builtin['b'] {
+			result.WriteString("(?s:.)")
+		} else {
+			result.WriteString(regexClass(positions[i]))
+		}
+		if count > 1 {
+			result.WriteString(fmt.Sprintf("{%d}", count))
+		}
+		i += count
+	}
+	result.WriteString("$")
+	return result.String(), nil
+}
+
+// regexClass converts a charset into a bracketed character class
+func regexClass(c masks.Charset) string {
+	bytes := c.Bytes()
+	var class strings.Builder
+	class.WriteString("[")
+	for i := 0; i < len(bytes); {
+		j := i
+		for j+1 < len(bytes) && bytes[j+1] == bytes[j]+1 {
+			j++
+		}
+		class.WriteString(regexByte(bytes[i]))
+		if j == i+1 {
+			class.WriteString(regexByte(bytes[j]))
+		} else if j > i+1 {
+			class.WriteString("-" + regexByte(bytes[j]))
+		}
+		i = j + 1
+	}
+	class.WriteString("]")
+	return class.String()
+}
+
+// regexByte escapes a single byte for use in a regular expression
+func regexByte(b byte) string {
+	switch {
+	case strings.IndexByte(`\.+*?()|[]{}^$-`, b) != -1:
+		return `\` + string(b)
+	case b >= ' ' && b <= '~':
+		return string(b)
+	default:
+		return fmt.Sprintf(`\x%02x`, b)
+	}
+}
+
+// RegexToMask converts a simple regular expression into a mask
+//
+// Only fixed length expressions made of literals and character classes can
+// be converted. Character classes that are not a built-in charset are
+// assigned custom charsets and returned as a .hcmask line such as
+// "?l?u,?1?1?d". Literal commas and backslashes are escaped as \, and \\ so
+// the line is read back correctly.
+//
+// Args:
+//
+//	expr (string): Regular expression to convert
+//
+// Returns:
+//
+//	(string): Mask or .hcmask line
+//	err (error): Error data
+func RegexToMask(expr string) (string, error) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+
+	var mask strings.Builder
+	custom := []masks.Charset{}
+	if err := regexToMask(re.Simplify(), &mask, &custom); err != nil {
+		return "", err
+	}
+
+	fields := []string{}
+	for _, c := range custom {
+		fields = append(fields, charsetDefinition(c))
+	}
+	return strings.Join(append(fields, masks.EscapeHCMask(mask.String())), ","), nil
+}
+
+// builtinOrder is the order built-in charsets are preferred when converting
+var builtinOrder = "ludsahHb"
+
+// regexToMask walks a simplified expression and writes the mask
+func regexToMask(re *syntax.Regexp, mask *strings.Builder, custom *[]masks.Charset) error {
+	switch re.Op {
+	case syntax.OpConcat, syntax.OpCapture:
+		for _, sub := range re.Sub {
+			if err := regexToMask(sub, mask, custom); err != nil {
+				return err
+			}
+		}
+	case syntax.OpBeginText, syntax.OpEndText, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpEmptyMatch:
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return fmt.Errorf("case insensitive literals cannot be converted")
+		}
+		for _, b := range []byte(string(re.Rune)) {
+			if b == '?' {
+				mask.WriteString("??")
+			} else {
+				mask.WriteByte(b)
+			}
+		}
+	case syntax.OpCharClass:
+		var c masks.Charset
+		for i := 0; i < len(re.Rune); i += 2 {
+			if re.Rune[i+1] > 0xff {
+				return fmt.Errorf("character class %s contains multibyte characters", re)
+			}
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				c.Add(byte(r))
+			}
+		}
+
+		return writeCharset(c, mask, custom)
+	default:
+		return fmt.Errorf("%s is not fixed length and cannot be converted", re)
+	}
+	return nil
+}
+
+// writeCharset writes a charset as a built-in charset when possible and
+// otherwise assigns it the next free custom charset
+func writeCharset(c masks.Charset, mask *strings.Builder, custom *[]masks.Charset) error {
+	for i := 0; i < len(builtinOrder); i++ {
+		if masks.Builtin[builtinOrder[i]] == c {
+			mask.WriteString("?" + string(builtinOrder[i]))
+			return nil
+		}
+	}
+
+	for i, existing := range *custom {
+		if existing == c {
+			mask.WriteString(fmt.Sprintf("?%d", i+1))
+			return nil
+		}
+	}
+	if len(*custom) == 4 {
+		return fmt.Errorf("mask needs more than four custom charsets")
+	}
+	*custom = append(*custom, c)
+	mask.WriteString(fmt.Sprintf("?%d", len(*custom)))
+	return nil
+}
+
+// charsetDefinition writes a charset as a custom charset definition using
+// built-in charsets where possible
+//
+// Commas and backslashes are escaped so the definition is read back the same
+// by masks.ParseHCMaskLine, including a backslash at the end of it.
+func charsetDefinition(c masks.Charset) string {
+	var def strings.Builder
+	remaining := c
+	for i := 0; i < len(builtinOrder); i++ {
+		builtin := masks.Builtin[builtinOrder[i]]
+		if builtin.Size() < 2 {
+			continue
+		}
+
+		covered := true
+		for _, b := range builtin.Bytes() {
+			if !remaining.Has(b) {
+				covered = false
+				break
+			}
+		}
+		if covered {
+			def.WriteString("?" + string(builtinOrder[i]))
+			for _, b := range builtin.Bytes() {
+				remaining[b/64] &^= 1 << (b % 64)
+			}
+		}
+	}
+
+	for _, b := range remaining.Bytes() {
+		switch b {
+		case '?':
+			def.WriteString("??")
+		case ',', '\\':
+			def.WriteString("\\" + string(b))
+		default:
+			def.WriteByte(b)
+		}
+	}
+	return def.String()
+}
+
+// ConvertHashcatToJohn converts a hashcat mask into a John the Ripper mask
+//
+// Built-in charsets are kept as John supports the same placeholders. Custom
+// charsets are written inline as [ ] ranges so no extra John options are
+// needed. Literal [, ] and \ characters are escaped with a backslash. John
+// does not support NUL so ?b covers 0x01 to 0xff in John.
+//
+// Args:
+//
+//	mask (string): Hashcat mask to convert
+//	custom (map[byte]masks.Charset): Custom charsets keyed by '1' to '4'
+//
+// Returns:
+//
+//	(string): John the Ripper mask
+//	err (error): Error data
+func ConvertHashcatToJohn(mask string, custom map[byte]masks.Charset) (string, error) {
+	var result strings.Builder
+	for i := 0; i < len(mask); i++ {
+		if mask[i] != '?' {
+			result.WriteString(johnLiteral(mask[i]))
+			continue
+		}
+		if i+1 >= len(mask) {
+			return "", fmt.Errorf("mask %q ends with a single ?", mask)
+		}
+
+		i++
+		switch class := mask[i]; {
+		case class == '?':
+			result.WriteString(johnLiteral('?'))
+		case strings.IndexByte(builtinOrder, class) != -1:
+			result.WriteString("?" + string(class))
+		case class >= '1' && class <= '4':
+			c, ok := custom[class]
+			if !ok {
+				return "", fmt.Errorf("custom charset ?%c is not defined", class)
+			}
+			result.WriteString(johnRange(c))
+		default:
+			return "", fmt.Errorf("invalid mask character ?%c", class)
+		}
+	}
+	return result.String(), nil
+}
+
+// ConvertJohnToHashcat converts a John the Ripper mask into a hashcat mask
+//
+// Inline [ ] ranges and ?B are matched to built-in charsets or assigned
+// custom charsets and returned as a .hcmask line such as "?l?d,?1?1?1".
+// John custom placeholders ?1 to ?9 are translated with the given
+// definitions. Constructs reported by models.FindUntranslatableJohn return an
+// error. Literal commas are escaped as \, so the mask is read back correctly.
+//
+// Args:
+//
+//	mask (string): John the Ripper mask to convert
+//	custom (map[byte]masks.Charset): John custom charsets keyed by '1' to '9'
+//
+// Returns:
+//
+//	(string): Mask or .hcmask line
+//	err (error): Error data
+func ConvertJohnToHashcat(mask string, custom map[byte]masks.Charset) (string, error) {
+	if found := models.FindUntranslatableJohn(mask); len(found) > 0 {
+		return "", fmt.Errorf("cannot translate %s", strings.Join(found, ", "))
+	}
+
+	var result strings.Builder
+	charsets := []masks.Charset{}
+	for i := 0; i < len(mask); i++ {
+		var c masks.Charset
+		switch mask[i] {
+		case '\\':
+			if i+1 < len(mask) {
+				i++
+			}
+			c.Add(mask[i])
+		case '?':
+			if i+1 == len(mask) {
+				return "", fmt.Errorf("mask %q ends with a single ?", mask)
+			}
+			i++
+			class, err := johnPlaceholder(mask[i], custom)
+			if err != nil {
+				return "", err
+			}
+			c = class
+		case '[':
+			end := i + 1
+			for end < len(mask) && mask[end] != ']' {
+				if mask[end] == '\\' || mask[end] == '?' {
+					end++
+				}
+				end++
+			}
+			class, err := johnRangeCharset(mask[i+1:end], custom)
+			if err != nil {
+				return "", err
+			}
+			c = class
+			i = end
+		default:
+			c.Add(mask[i])
+		}
+
+		if c.Size() == 1 {
+			if b := c.Bytes()[0]; b == '?' {
+				result.WriteString("??")
+			} else {
+				result.WriteByte(b)
+			}
+			continue
+		}
+		if err := writeCharset(c, &result, &charsets); err != nil {
+			return "", err
+		}
+	}
+
+	fields := []string{}
+	for _, c := range charsets {
+		fields = append(fields, charsetDefinition(c))
+	}
+	return strings.Join(append(fields, masks.EscapeHCMask(result.String())), ","), nil
+}
+
+// johnPlaceholder returns the charset of a John placeholder character
+func johnPlaceholder(class byte, custom map[byte]masks.Charset) (masks.Charset, error) {
+	switch {
+	case class == '?':
+		return masks.NewCharset("?"), nil
+	case class == 'b':
+		// John does not support NUL so ?b starts at 0x01
+		c := masks.Builtin['b']
+		c[0] &^= 1
+		return c, nil
+	case class == 'B':
+		var c masks.Charset
+		for b := 0x80; b <= 0xff; b++ {
+			c.Add(byte(b))
+		}
+		return c, nil
+	case class >= '1' && class <= '9':
+		c, ok := custom[class]
+		if !ok {
+			return masks.Charset{}, fmt.Errorf("custom charset ?%c is not defined", class)
+		}
+		return c, nil
+	}
+
+	c, ok := masks.Builtin[class]
+	if !ok {
+		return masks.Charset{}, fmt.Errorf("invalid mask character ?%c", class)
+	}
+	return c, nil
+}
+
+// johnRangeCharset parses the inside of a John [ ] range into a charset
+func johnRangeCharset(def string, custom map[byte]masks.Charset) (masks.Charset, error) {
+	var c masks.Charset
+	for i := 0; i < len(def); i++ {
+		b := def[i]
+		switch b {
+		case '?':
+			if i+1 == len(def) {
+				return c, fmt.Errorf("range [%s] ends with a single ?", def)
+			}
+			class, err := johnPlaceholder(def[i+1], custom)
+			if err != nil {
+				return c, err
+			}
+			c.Union(class)
+			i++
+			continue
+		case '\\':
+			if i+1 < len(def) {
+				i++
+				b = def[i]
+			}
+		}
+
+		if i+2 < len(def) && def[i+1] == '-' {
+			hi := def[i+2]
+			if hi == '\\' && i+3 < len(def) {
+				hi = def[i+3]
+				i++
+			}
+			if hi < b {
+				return c, fmt.Errorf("invalid range %c-%c", b, hi)
+			}
+			for r := int(b); r <= int(hi); r++ {
+				c.Add(byte(r))
+			}
+			i += 2
+			continue
+		}
+		c.Add(b)
+	}
+
+	if c.Size() == 0 {
+		return c, fmt.Errorf("empty range []")
+	}
+	return c, nil
+}
+
+// johnRange writes a charset as a John [ ] range
+func johnRange(c masks.Charset) string {
+	bytes := c.Bytes()
+	var result strings.Builder
+	result.WriteString("[")
+	for i := 0; i < len(bytes); {
+		j := i
+		for j+1 < len(bytes) && bytes[j+1] == bytes[j]+1 {
+			j++
+		}
+		result.WriteString(johnRangeByte(bytes[i]))
+		if j == i+1 {
+			result.WriteString(johnRangeByte(bytes[j]))
+		} else if j > i+1 {
+			result.WriteString("-" + johnRangeByte(bytes[j]))
+		}
+		i = j + 1
+	}
+	result.WriteString("]")
+	return result.String()
+}
+
+// johnRangeByte escapes a byte for use inside a John [ ] range
+func johnRangeByte(b byte) string {
+	if strings.IndexByte(`[]\-?`, b) != -1 {
+		return `\` + string(b)
+	}
+	return string(b)
+}
+
+// johnLiteral escapes a literal byte for use in a John mask
+func johnLiteral(b byte) string {
+	if strings.IndexByte(`[]\?`, b) != -1 {
+		return `\` + string(b)
+	}
+	return string(b)
+}
+
 // MakeToken parses out tokens into an array
 //   - Parses out camel case
 //   - Parses out digit boundaries
@@ -115,6 +838,226 @@ func MakeToken(str string) []string {
 	return result
 }
 
+// SplitTemplate splits a combinator template into slots and literal text
+//
+// Each ?w is a slot for a word of any case and length. Runs of ?l and ?u,
+// runs of ?d and runs of ?s are slots for a token with exactly that mask so
+// ?w?d?d?d?d?s has a word, a four digit and a special slot. ?? is a literal
+// question mark and any other text is literal.
+//
+// Args:
+//
+//	template (string): Template such as ?w?d?d?d?d?s
+//
+// Returns:
+//
+//	parts ([]models.TemplatePart): Parts of the template in order
+//	(error): Error if the template uses an unsupported placeholder
+func SplitTemplate(template string) ([]models.TemplatePart, error) {
+	parts := []models.TemplatePart{}
+	add := func(text string, slot bool, class byte, lastClass *byte) {
+		last := len(parts) - 1
+		if last >= 0 && parts[last].Slot == slot && (!slot || (class != 'w' && class == *lastClass)) {
+			parts[last].Text += text
+		} else {
+			parts = append(parts, models.TemplatePart{Text: text, Slot: slot})
+		}
+		*lastClass = class
+	}
+
+	lastClass := byte(0)
+	for i := 0; i < len(template); i++ {
+		if template[i] != '?' {
+			add(template[i:i+1], false, 0, &lastClass)
+			continue
+		}
+		if i+1 == len(template) {
+			return nil, fmt.Errorf("template %q ends with a single ?", template)
+		}
+
+		i++
+		switch template[i] {
+		case '?':
+			add("?", false, 0, &lastClass)
+		case 'w', 'd', 's':
+			add(template[i-1:i+1], true, template[i], &lastClass)
+		case 'l', 'u':
+			add(template[i-1:i+1], true, 'l', &lastClass)
+		default:
+			return nil, fmt.Errorf("template placeholder ?%c is not supported", template[i])
+		}
+	}
+	return parts, nil
+}
+
+// FitsSlot tests if a token fits a template slot
+//
+// Args:
+//
+//	token (string): Token to test
+//	slot (string): Slot made by SplitTemplate
+//
+// Returns:
+//
+//	(bool): If the token fits the slot
+func FitsSlot(token string, slot string) bool {
+	if slot == "?w" {
+		return ClassifyToken(token) == "alpha"
+	}
+	return len(token)*2 == len(slot) && MakeMask(token, slotReplacements) == slot
+}
+
+var slotReplacements = ConstructReplacements("ulds")
+
+// ClassifyToken returns the character class of a token
+//
+// The following classes are returned:
+//   - multibyte for tokens containing non-ASCII characters
+//   - alpha for tokens containing only alpha characters
+//   - digit for tokens containing only numerical characters
+//   - special for tokens containing only special characters
+//   - mixed for tokens containing more than one class
+//
+// Args:
+//
+//	token (string): Input token
+//
+// Returns:
+//
+//	(string): Class of the token
+func ClassifyToken(token string) string {
+	switch {
+	case !models.IsStringASCII(token):
+		return "multibyte"
+	case models.IsStringInt(token):
+		return "digit"
+	case models.IsStringSpecial(token):
+		return "special"
+	case token != "" && models.IsStringAlpha(token):
+		return "alpha"
+	default:
+		return "mixed"
+	}
+}
+
+// ParseWeightedToken parses a line from a token file into a weighted token
+//
+// The following line formats are accepted:
+//   - token\tweight where weight is a number
+//   - count:token where count is an integer such as tokens -count output
+//   - token which is given a weight of 1
+//
+// Args:
+//
+//	line (string): Line from a token file
+//
+// Returns:
+//
+//	(models.WeightedToken): Parsed weighted token
+//	(bool): If the line contained a token
+func ParseWeightedToken(line string) (models.WeightedToken, bool) {
+	if i := strings.LastIndex(line, "\t"); i != -1 {
+		if weight, err := strconv.ParseFloat(line[i+1:], 64); err == nil && line[:i] != "" {
+			return models.WeightedToken{Token: line[:i], Weight: weight}, true
+		}
+	}
+
+	if i := strings.Index(line, ":"); i > 0 && models.IsStringInt(line[:i]) && line[i+1:] != "" {
+		count, err := strconv.ParseFloat(line[:i], 64)
+		if err == nil {
+			return models.WeightedToken{Token: line[i+1:], Weight: count}, true
+		}
+	}
+
+	if line == "" {
+		return models.WeightedToken{}, false
+	}
+	return models.WeightedToken{Token: line, Weight: 1}, true
+}
+
+// SortWeightedTokens sorts weighted tokens by weight descending while keeping
+// the original order of tokens with equal weights
+//
+// Args:
+//
+//	tokens ([]models.WeightedToken): Tokens to sort in place
+//
+// Returns:
+//
+//	None
+func SortWeightedTokens(tokens []models.WeightedToken) {
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return tokens[i].Weight > tokens[j].Weight
+	})
+}
+
+// CapTokensPerSlot limits the number of tokens that share the same token mask
+//
+// Tokens are expected to already be sorted so the highest weighted tokens for
+// each slot are kept.
+//
+// Args:
+//
+//	tokens ([]models.WeightedToken): Sorted tokens to limit
+//	replacements ([]string): Replacement array used to make token masks
+//	maxPerSlot (int): Max number of tokens per token mask (0 keeps all)
+//
+// Returns:
+//
+//	kept ([]models.WeightedToken): Tokens within the limit
+func CapTokensPerSlot(tokens []models.WeightedToken, replacements []string, maxPerSlot int) []models.WeightedToken {
+	if maxPerSlot <= 0 {
+		return tokens
+	}
+
+	slots := make(map[string]int)
+	kept := []models.WeightedToken{}
+	for _, token := range tokens {
+		tokenmask := models.EnsureValidMask(MakeMask(token.Token, replacements))
+		if slots[tokenmask] >= maxPerSlot {
+			continue
+		}
+		slots[tokenmask]++
+		kept = append(kept, token)
+	}
+	return kept
+}
+
+// SampleIndexes randomly selects k unique indexes from n items
+//
+// The selected indexes are returned in ascending order so the original order
+// of the items, such as weight order, is preserved. Floyd's algorithm is used
+// so the work and memory grow with k rather than n. Nil is returned when k
+// does not select fewer than n items so callers can use every item directly.
+//
+// Args:
+//
+//	n (int): Number of items to select from
+//	k (int): Number of items to select
+//	rng (*rand.Rand): Random source to use
+//
+// Returns:
+//
+//	indexes ([]int): Selected indexes in ascending order or nil for every item
+func SampleIndexes(n int, k int, rng *rand.Rand) []int {
+	if k <= 0 || k >= n {
+		return nil
+	}
+
+	selected := make(map[int]struct{}, k)
+	indexes := make([]int, 0, k)
+	for j := n - k; j < n; j++ {
+		i := rng.Intn(j + 1)
+		if _, ok := selected[i]; ok {
+			i = j
+		}
+		selected[i] = struct{}{}
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
 // RemoveMaskCharacters will replace mask characters in a string with nothing
 //
 // Args:
@@ -125,7 +1068,7 @@ func MakeToken(str string) []string {
 //
 //	(string): String with replaced characters
 func RemoveMaskCharacters(str string) string {
-	return strings.NewReplacer("?u", "", "?l", "", "?d", "", "?b", "", "?s", "").Replace(str)
+	return strings.NewReplacer("?u", "", "?l", "", "?d", "", "?b", "", "?s", "", "?h", "", "?H", "", "?a", "").Replace(str)
 }
 
 // TestComplexity tests the complexity of an input mask
@@ -139,7 +1082,7 @@ func RemoveMaskCharacters(str string) string {
 //	(int): Complexity score as an integer
 func TestComplexity(str string) int {
 	complexity := 0
-	charTypes := []string{"?u", "?l", "?d", "?s", "?b"}
+	charTypes := []string{"?u", "?l", "?d", "?s", "?b", "?h", "?H", "?a"}
 	for _, charType := range charTypes {
 		if strings.Contains(str, charType) {
 			complexity++
@@ -168,6 +1111,9 @@ func TestEntropy(str string) int {
 		{"?d", 10},
 		{"?s", 33},
 		{"?b", 256},
+		{"?h", 16},
+		{"?H", 16},
+		{"?a", 95},
 	}
 	for _, ct := range charTypes {
 		entropy += strings.Count(str, ct.charType) * ct.count
@@ -289,10 +1235,13 @@ func TestHexInput(s string) bool {
 //
 // # Retain masks are masks where keywords are prevented from being transformed
 //
+// Tokens are retained in the order given so when tokens overlap the earlier,
+// higher weighted, token is kept.
+//
 // Args:
 //
 //		stringWord (string): Input string to turn into a retain mask
-//		retainTokens (map[string]struct{}): Tokens that should be kept
+//		retainTokens ([]models.WeightedToken): Tokens that should be kept in priority order
 //	 args ([]string): Replacer arguments to use
 //		doMultiByte	(bool): If the function should process multibyte text
 //		doNumberOfReplacements (int): Number of tokens to keep in each string (default 1)
@@ -300,16 +1249,29 @@ func TestHexInput(s string) bool {
 // Returns:
 //
 //	(string): Mask with any tokens retained
-func CreateRetainMask(stringWord string, retainTokens map[string]struct{}, args []string, doMultiByte bool, doNumberOfReplacements int) string {
+func CreateRetainMask(stringWord string, retainTokens []models.WeightedToken, args []string, doMultiByte bool, doNumberOfReplacements int) string {
+	retained := make(map[string]struct{}, len(retainTokens))
+	for _, token := range retainTokens {
+		retained[token.Token] = struct{}{}
+	}
+
 	// Create the retain mask
 	result := []string{stringWord}
 
 	// Iterate on tokens
-	for value := range retainTokens {
+	for _, token := range retainTokens {
+		value := token.Token
+		if value == "" {
+			continue
+		}
 		var temp []string
 
-		// Iterate on item text
+		// Iterate on item text and keep text already retained whole
 		for _, s := range result {
+			if _, ok := retained[s]; ok {
+				temp = append(temp, s)
+				continue
+			}
 			split := strings.Split(s, value)
 
 			// Iterate on exploded string
@@ -332,7 +1294,7 @@ func CreateRetainMask(stringWord string, retainTokens map[string]struct{}, args
 	forward := false
 
 	for i, s := range result {
-		if _, ok := retainTokens[s]; !ok || override {
+		if _, ok := retained[s]; !ok || override {
 
 			look := i + 1
 			if look > len(result)-1 {
@@ -340,7 +1302,7 @@ func CreateRetainMask(stringWord string, retainTokens map[string]struct{}, args
 			}
 
 			// Limiting fowards to one for now
-			if _, forwardOk := retainTokens[s+result[look]]; forwardOk && forward == false {
+			if _, forwardOk := retained[s+result[look]]; forwardOk && forward == false {
 				forward = true
 				continue
 			} else if forward {
@@ -2,8 +2,12 @@ package utils
 
 import (
 	"fmt"
+	"math/rand"
 	"reflect"
 	"testing"
+
+	"github.com/jakewnuk/maskcat/pkg/masks"
+	"github.com/jakewnuk/maskcat/pkg/models"
 )
 
 func TestConstructReplacements(t *testing.T) {
@@ -54,6 +58,274 @@ func TestMakeMask(t *testing.T) {
 	}
 }
 
+func TestMakeHexMask(t *testing.T) {
+	replacements := ConstructReplacements("ulds")
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"a3f09b", "?h?h?h?h?h?h"},
+		{"A3F09B", "?H?H?H?H?H?H"},
+		{"key-a3f09b12", "?l?l?l?s?h?h?h?h?h?h?h?h"},
+		{"A3f09b", "?u?d?l?d?d?l"},
+		{"cafe", "?l?l?l?l"},
+		{"20241231", "?d?d?d?d?d?d?d?d"},
+		{"ab12", "?l?l?d?d"},
+	}
+
+	for _, test := range tests {
+		got := MakeHexMask(test.input, replacements, 6)
+		if got != test.want {
+			t.Errorf("MakeHexMask(%q) = %q; want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestMakeSemanticMask(t *testing.T) {
+	replacements := ConstructReplacements("ulds")
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Summer2023!", "12,09,0123,01,?u?l?l?l?l?l?1?2?d?d?s"},
+		{"bob25121990", "12,09,0123,01,?l?l?l?3?d?4?d?1?2?d?d"},
+		{"March!", "?u?l?l?l?l?s"},
+		{"pass1234", "?l?l?l?l?d?d?d?d"},
+	}
+
+	for _, test := range tests {
+		got := MakeSemanticMask(test.input, replacements)
+		if got != test.want {
+			t.Errorf("MakeSemanticMask(%q) = %q; want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestExpandSemanticMask(t *testing.T) {
+	replacements := ConstructReplacements("ulds")
+	got := []string{}
+	ExpandSemanticMask("Summer23", replacements, 0, func(mask string) bool {
+		got = append(got, mask)
+		return true
+	})
+	want := []string{"Summer?d?d", "Spring?d?d", "Autumn?d?d", "Fall?d?d", "Winter?d?d"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExpandSemanticMask(%q) = %q; want %q", "Summer23", got, want)
+	}
+
+	got = []string{}
+	ExpandSemanticMask("Fall2023!", replacements, 0, func(mask string) bool {
+		got = append(got, mask)
+		return len(got) < 3
+	})
+	want = []string{"Fall2023?s", "Fall2022?s", "Fall2024?s"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExpandSemanticMask(%q) = %q; want %q", "Fall2023!", got, want)
+	}
+
+	got = []string{}
+	ExpandSemanticMask("25121990x01012000", replacements, 4, func(mask string) bool {
+		got = append(got, mask)
+		return true
+	})
+	want = []string{"25121990?l01012000", "25121990?l31121999", "25121990?l02012000", "25121990?l30121999"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExpandSemanticMask(%q) with a limit of 4 = %q; want %q", "25121990x01012000", got, want)
+	}
+}
+
+func TestCollapseMask(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"?u?l?d?s?h?H", "?a?a?a?a?a?a"},
+		{"Summer?d??", "Summer?a??"},
+		{"?b?1?l", "?b?1?a"},
+	}
+
+	for _, test := range tests {
+		got := CollapseMask(test.input)
+		if got != test.want {
+			t.Errorf("CollapseMask(%q) = %q; want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestMakeCustomMask(t *testing.T) {
+	custom := map[byte]masks.Charset{'1': masks.NewCharset("\xc3\xa4\xb6\xbc"), '2': masks.NewCharset("\xc3\x9f")}
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"?u?l?d", "?u?l?d"},
+		{"Gr?lße", "Gr?l?1?2e"},
+		{"?l\xc3\xa4?l", "?l?1?1?l"},
+		{"?l\xe2\x82\xac", "?l\xe2\x82\xac"},
+	}
+
+	for _, test := range tests {
+		got := MakeCustomMask(test.input, custom)
+		if got != test.want {
+			t.Errorf("MakeCustomMask(%q) = %q; want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestMaskToRegex(t *testing.T) {
+	custom := map[byte]masks.Charset{'1': masks.NewCharset("abc")}
+	tests := []struct {
+		input string
+		want  string
+		err   bool
+	}{
+		{"?l?l?l?l?d?d", "^[a-z]{4}[0-9]{2}$", false},
+		{"?u?s", "^[A-Z][ -/:-@\\[-`\\{-~]$", false},
+		{"Summer?d?d??", "^Summer[0-9]{2}\\?$", false},
+		{"?1?a", "^[a-c][ -~]$", false},
+		{"Über?d", "^Über[0-9]$", false},
+		{"?b", "^(?s:.)$", false},
+		{"?b?b", "^(?s:.){2}$", false},
+		{"?2", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := MaskToRegex(test.input, custom)
+		if got != test.want || (err != nil) != test.err {
+			t.Errorf("MaskToRegex(%q) = (%q, %v); want (%q, err=%v)", test.input, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestRegexToMask(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		err   bool
+	}{
+		{"[a-z]{4}\\d{2}", "?l?l?l?l?d?d", false},
+		{"^Summer[0-9]{2}\\?$", "Summer?d?d??", false},
+		{"[a-zA-Z]{2}[0-9a-f]", "?l?u,?1?1?h", false},
+		{"[,.]x", "\\,.,?1x", false},
+		{"a,b[0-9]", "a\\,b?d", false},
+		{"[xy],", "xy,?1\\,", false},
+		{"[a-z]+", "", true},
+		{"(?i)ab", "", true},
+		{"a|b", "ab,?1", false},
+		{"a|bc", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := RegexToMask(test.input)
+		if got != test.want || (err != nil) != test.err {
+			t.Errorf("RegexToMask(%q) = (%q, %v); want (%q, err=%v)", test.input, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestRegexToMaskParse(t *testing.T) {
+	tests := []struct {
+		input  string
+		accept []string
+		reject []string
+	}{
+		{"[A\\\\]{2}", []string{"AA", "A\\", "\\\\"}, []string{"A,", "AB"}},
+		{"[\\\\,]x", []string{"\\x", ",x"}, []string{"ax"}},
+		{"a\\\\[0-9]", []string{"a\\1"}, []string{"a1", "a\\\\"}},
+		{"[,.]\\\\,", []string{",\\,", ".\\,"}, []string{",\\."}},
+	}
+
+	for _, test := range tests {
+		line, err := RegexToMask(test.input)
+		if err != nil {
+			t.Fatalf("RegexToMask(%q) returned %v", test.input, err)
+		}
+		mask, charsets, err := masks.ParseHCMaskLine(line, nil)
+		if err != nil {
+			t.Fatalf("ParseHCMaskLine(%q) returned %v", line, err)
+		}
+
+		x := masks.NewIndex()
+		if err := x.Add(mask, charsets, 0); err != nil {
+			t.Fatalf("Index.Add(%q) from %q returned %v", mask, line, err)
+		}
+		for _, plaintext := range test.accept {
+			if _, ok := x.Match(plaintext); !ok {
+				t.Errorf("line %q from RegexToMask(%q) did not match %q", line, test.input, plaintext)
+			}
+		}
+		for _, plaintext := range test.reject {
+			if _, ok := x.Match(plaintext); ok {
+				t.Errorf("line %q from RegexToMask(%q) matched %q", line, test.input, plaintext)
+			}
+		}
+	}
+}
+
+func TestConvertHashcatToJohn(t *testing.T) {
+	custom := map[byte]masks.Charset{'1': masks.NewCharset("abcx")}
+	tests := []struct {
+		input string
+		want  string
+		err   bool
+	}{
+		{"?u?l?d?s?a?b", "?u?l?d?s?a?b", false},
+		{"Summer?d?d??", "Summer?d?d\\?", false},
+		{"?1?1[x]", "[a-cx][a-cx]\\[x\\]", false},
+		{"?2", "", true},
+		{"?d?", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := ConvertHashcatToJohn(test.input, custom)
+		if got != test.want || (err != nil) != test.err {
+			t.Errorf("ConvertHashcatToJohn(%q) = (%q, %v); want (%q, err=%v)", test.input, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestConvertJohnToHashcat(t *testing.T) {
+	custom := map[byte]masks.Charset{'5': masks.NewCharset("xyz")}
+	tests := []struct {
+		input string
+		want  string
+		err   bool
+	}{
+		{"?u?l?d?s", "?u?l?d?s", false},
+		{"[a-z][0-9]x", "?l?dx", false},
+		{"[a-zA-Z][?l?d]", "?l?u,?l?d,?1?2", false},
+		{"Summer\\?[0-9]??", "Summer???d??", false},
+		{"?5?5", "xyz,?1?1", false},
+		{"[a]b", "ab", false},
+		{"a,b?d", "a\\,b?d", false},
+		{"[,.]x,", "\\,.,?1x\\,", false},
+		{"[A\\\\]\\\\", "A\\\\,?1\\\\", false},
+		{"?w?d?d", "", true},
+		{"?L?U", "", true},
+		{"?6", "", true},
+		{"[z-a]", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := ConvertJohnToHashcat(test.input, custom)
+		if got != test.want || (err != nil) != test.err {
+			t.Errorf("ConvertJohnToHashcat(%q) = (%q, %v); want (%q, err=%v)", test.input, got, err, test.want, test.err)
+		}
+	}
+	line, err := ConvertJohnToHashcat("[A\\\\][,\\\\]", custom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mask, charsets, err := masks.ParseHCMaskLine(line, nil)
+	if err != nil || mask != "?1?2" || charsets['1'] != masks.NewCharset("A\\") || charsets['2'] != masks.NewCharset(",\\") {
+		t.Errorf("ParseHCMaskLine(%q) = (%q, %v, %v); want charsets A\\ and ,\\ for ?1?2", line, mask, charsets, err)
+	}
+	// Ranges are checked without relying on models.FindUntranslatableJohn
+	if _, err := johnRangeCharset("a?", custom); err == nil {
+		t.Errorf("johnRangeCharset(%q) returned no error", "a?")
+	}
+}
+
 func TestMakeToken(t *testing.T) {
 	str := "ThisApple123OfMine"
 	want := []string{"This", "Apple", "123", "Of", "Mine", "ThisAppleOfMine"}
@@ -63,21 +335,129 @@ func TestMakeToken(t *testing.T) {
 	}
 }
 
+func TestClassifyToken(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Apple", "alpha"},
+		{"2024", "digit"},
+		{"!!", "special"},
+		{"abc123", "mixed"},
+		{"über", "multibyte"},
+	}
+
+	for _, test := range tests {
+		got := ClassifyToken(test.input)
+		if got != test.want {
+			t.Errorf("ClassifyToken(%q) = %q; want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestParseWeightedToken(t *testing.T) {
+	tests := []struct {
+		input string
+		want  models.WeightedToken
+		ok    bool
+	}{
+		{"2024", models.WeightedToken{Token: "2024", Weight: 1}, true},
+		{"15:2024", models.WeightedToken{Token: "2024", Weight: 15}, true},
+		{"love\t2.5", models.WeightedToken{Token: "love", Weight: 2.5}, true},
+		{"a:b", models.WeightedToken{Token: "a:b", Weight: 1}, true},
+		{"3:", models.WeightedToken{Token: "3:", Weight: 1}, true},
+		{"", models.WeightedToken{}, false},
+	}
+
+	for _, test := range tests {
+		got, ok := ParseWeightedToken(test.input)
+		if got != test.want || ok != test.ok {
+			t.Errorf("ParseWeightedToken(%q) = (%v, %v); want (%v, %v)", test.input, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestSortWeightedTokens(t *testing.T) {
+	tokens := []models.WeightedToken{{Token: "a", Weight: 1}, {Token: "b", Weight: 5}, {Token: "c", Weight: 1}}
+	want := []models.WeightedToken{{Token: "b", Weight: 5}, {Token: "a", Weight: 1}, {Token: "c", Weight: 1}}
+	SortWeightedTokens(tokens)
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("SortWeightedTokens() = %v; want %v", tokens, want)
+	}
+}
+
+func TestCapTokensPerSlot(t *testing.T) {
+	tokens := []models.WeightedToken{{Token: "2024", Weight: 9}, {Token: "2023", Weight: 5}, {Token: "love", Weight: 3}, {Token: "1999", Weight: 1}}
+	want := []models.WeightedToken{{Token: "2024", Weight: 9}, {Token: "2023", Weight: 5}, {Token: "love", Weight: 3}}
+	got := CapTokensPerSlot(tokens, ConstructReplacements("ulds"), 2)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CapTokensPerSlot() = %v; want %v", got, want)
+	}
+}
+
+func TestSampleIndexes(t *testing.T) {
+	if got := SampleIndexes(3, 0, rand.New(rand.NewSource(1))); got != nil {
+		t.Errorf("SampleIndexes(3, 0) = %v; want nil", got)
+	}
+	if got := SampleIndexes(3, 3, rand.New(rand.NewSource(1))); got != nil {
+		t.Errorf("SampleIndexes(3, 3) = %v; want nil", got)
+	}
+
+	counts := make([]int, 10)
+	for seed := int64(0); seed < 2000; seed++ {
+		for _, i := range SampleIndexes(10, 3, rand.New(rand.NewSource(seed))) {
+			counts[i]++
+		}
+	}
+	for i, count := range counts {
+		if count < 450 || count > 750 {
+			t.Errorf("SampleIndexes(10, 3) selected index %d %d times in 2000 samples; want about 600", i, count)
+		}
+	}
+
+	got := SampleIndexes(100, 5, rand.New(rand.NewSource(1)))
+	again := SampleIndexes(100, 5, rand.New(rand.NewSource(1)))
+	if len(got) != 5 || !reflect.DeepEqual(got, again) {
+		t.Errorf("SampleIndexes(100, 5) = %v and %v; want 5 identical indexes", got, again)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] <= got[i-1] {
+			t.Errorf("SampleIndexes(100, 5) = %v; want ascending unique indexes", got)
+		}
+	}
+}
+
 func TestTestComplexity(t *testing.T) {
-	str := "?u?l?l?l?l?s?s?u?l?l?l?l?d?s"
-	want := 4
-	got := TestComplexity(str)
-	if got != want {
-		t.Errorf("TestComplexity(%q) = %d; want %d", str, got, want)
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"?u?l?l?l?l?s?s?u?l?l?l?l?d?s", 4},
+		{"?h?h?h?H?a", 3},
+	}
+
+	for _, test := range tests {
+		got := TestComplexity(test.input)
+		if got != test.want {
+			t.Errorf("TestComplexity(%q) = %d; want %d", test.input, got, test.want)
+		}
 	}
 }
 
 func TestTestEntropy(t *testing.T) {
-	str := "?u?l?l?l?l?s?s?u?l?l?l?l?d?s"
-	want := 369
-	got := TestEntropy(str)
-	if got != want {
-		t.Errorf("TestEntropy(%q) = %d; want %d", str, got, want)
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"?u?l?l?l?l?s?s?u?l?l?l?l?d?s", 369},
+		{"?h?h?H?a", 143},
+	}
+
+	for _, test := range tests {
+		got := TestEntropy(test.input)
+		if got != test.want {
+			t.Errorf("TestEntropy(%q) = %d; want %d", test.input, got, test.want)
+		}
 	}
 }
 
@@ -93,8 +473,28 @@ func TestReplaceWordByMask(t *testing.T) {
 	}
 }
 
+func TestCreateRetainMask(t *testing.T) {
+	pass := models.WeightedToken{Token: "pass", Weight: 5}
+	password := models.WeightedToken{Token: "password", Weight: 1}
+	tests := []struct {
+		tokens []models.WeightedToken
+		want   string
+	}{
+		{[]models.WeightedToken{pass, password}, "pass?l?l?l?l?d"},
+		{[]models.WeightedToken{password, pass}, "password?d"},
+		{[]models.WeightedToken{}, "?l?l?l?l?l?l?l?l?d"},
+	}
+
+	for _, test := range tests {
+		got := CreateRetainMask("password1", test.tokens, ConstructReplacements("ulds"), false, 1)
+		if got != test.want {
+			t.Errorf("CreateRetainMask(%q, %v) = %q; want %q", "password1", test.tokens, got, test.want)
+		}
+	}
+}
+
 func TestRemoveMaskChars(t *testing.T) {
-	str := "?u?l?d?s"
+	str := "?u?l?d?s?h?H?a"
 	want := ""
 	got := RemoveMaskCharacters(str)
 	if got != want {
@@ -143,3 +543,49 @@ func TestTestHexInput(t *testing.T) {
 		}
 	}
 }
+
+func TestSplitTemplate(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []models.TemplatePart
+	}{
+		{"?w?d?d?d?d?s", []models.TemplatePart{{Text: "?w", Slot: true}, {Text: "?d?d?d?d", Slot: true}, {Text: "?s", Slot: true}}},
+		{"?w?w", []models.TemplatePart{{Text: "?w", Slot: true}, {Text: "?w", Slot: true}}},
+		{"?u?l?l-??1", []models.TemplatePart{{Text: "?u?l?l", Slot: true}, {Text: "-?1", Slot: false}}},
+	}
+
+	for _, test := range tests {
+		got, err := SplitTemplate(test.input)
+		if err != nil || !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("SplitTemplate(%q) = %v, %v; want %v", test.input, got, err, test.expected)
+		}
+	}
+
+	for _, input := range []string{"?w?a", "?w?"} {
+		if _, err := SplitTemplate(input); err == nil {
+			t.Errorf("SplitTemplate(%q) did not return an error", input)
+		}
+	}
+}
+
+func TestFitsSlot(t *testing.T) {
+	tests := []struct {
+		token    string
+		slot     string
+		expected bool
+	}{
+		{"Summer", "?w", true},
+		{"summer1", "?w", false},
+		{"2024", "?d?d?d?d", true},
+		{"202", "?d?d?d?d", false},
+		{"!", "?s", true},
+		{"Bob", "?u?l?l", true},
+		{"bob", "?u?l?l", false},
+	}
+
+	for _, test := range tests {
+		if got := FitsSlot(test.token, test.slot); got != test.expected {
+			t.Errorf("FitsSlot(%q, %q) = %v; want %v", test.token, test.slot, got, test.expected)
+		}
+	}
+}